@@ -0,0 +1,212 @@
+// Package errs defines the typed errors returned across the go-crud packages and maps them to HTTP responses.
+//
+// Every error carries a Kind, which can be matched with errors.Is:
+//
+//	if errors.Is(err, errs.NotFound) { ... }
+package errs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Kind classifies an error. Kinds are themselves errors so they can be used as errors.Is targets.
+type Kind string
+
+const (
+	NotFound     Kind = "not_found"
+	Conflict     Kind = "conflict"
+	Invalid      Kind = "invalid"
+	Unauthorized Kind = "unauthorized"
+	Forbidden    Kind = "forbidden"
+	RateLimited  Kind = "rate_limited"
+	Canceled     Kind = "canceled"
+	Timeout      Kind = "timeout"
+	Internal     Kind = "internal"
+)
+
+func (k Kind) Error() string { return string(k) }
+
+// Error is a typed error with a Kind, a message and an optional underlying cause.
+type Error struct {
+	Kind    Kind
+	Message string
+	Err     error
+
+	// code is the gRPC code of the original error, kept so status.Code still reports it
+	code codes.Code
+}
+
+// New returns an error of the given kind.
+func New(kind Kind, message string) *Error {
+	return &Error{Kind: kind, Message: message}
+}
+
+// Newf returns an error of the given kind with a formatted message.
+func Newf(kind Kind, format string, args ...interface{}) *Error {
+	return New(kind, fmt.Sprintf(format, args...))
+}
+
+// Wrap returns an error of the given kind wrapping err.
+func Wrap(kind Kind, err error, message string) *Error {
+	return &Error{Kind: kind, Message: message, Err: err}
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Err == nil:
+		return e.Message
+	case e.Message == "":
+		return e.Err.Error()
+	}
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is matches the error's Kind.
+func (e *Error) Is(target error) bool {
+	kind, ok := target.(Kind)
+	return ok && kind == e.Kind
+}
+
+// GRPCStatus lets status.Code report the error's gRPC code, keeping compatibility with callers that
+// inspect gRPC status codes.
+func (e *Error) GRPCStatus() *status.Status {
+	code := e.code
+	if code == codes.OK {
+		code = grpcCodes[e.Kind]
+	}
+	return status.New(code, e.Error())
+}
+
+var grpcCodes = map[Kind]codes.Code{
+	NotFound:     codes.NotFound,
+	Conflict:     codes.AlreadyExists,
+	Invalid:      codes.InvalidArgument,
+	Unauthorized: codes.Unauthenticated,
+	Forbidden:    codes.PermissionDenied,
+	RateLimited:  codes.ResourceExhausted,
+	Canceled:     codes.Canceled,
+	Timeout:      codes.DeadlineExceeded,
+	Internal:     codes.Internal,
+}
+
+// From converts err into a typed error, classifying gRPC status errors, Google API errors and
+// storage errors. Errors that are already typed are returned unchanged.
+func From(err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	kind := KindOf(err)
+	return &Error{Kind: kind, Err: err, code: codeFor(err, kind)}
+}
+
+// KindOf returns the Kind of err, classifying errors that are not already typed. A nil error has no kind.
+func KindOf(err error) Kind {
+	if err == nil {
+		return ""
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+
+	if errors.Is(err, storage.ErrObjectNotExist) || errors.Is(err, storage.ErrBucketNotExist) {
+		return NotFound
+	}
+	if errors.Is(err, context.Canceled) {
+		return Canceled
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return Timeout
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return kindFromHTTPStatus(apiErr.Code)
+	}
+
+	switch status.Code(err) {
+	case codes.NotFound:
+		return NotFound
+	case codes.AlreadyExists, codes.Aborted, codes.FailedPrecondition:
+		return Conflict
+	case codes.InvalidArgument, codes.OutOfRange:
+		return Invalid
+	case codes.Unauthenticated:
+		return Unauthorized
+	case codes.PermissionDenied:
+		return Forbidden
+	case codes.ResourceExhausted:
+		return RateLimited
+	case codes.Canceled:
+		return Canceled
+	case codes.DeadlineExceeded:
+		return Timeout
+	}
+	return Internal
+}
+
+// Code returns the gRPC code of err, translating the HTTP errors returned by the storage and Google API
+// clients. A nil error has code OK. It is the code reported by metrics, traces and retries.
+func Code(err error) codes.Code {
+	return codeFor(err, KindOf(err))
+}
+
+// codeFor returns the gRPC code of err, falling back to the code of its kind for errors such as
+// storage.ErrObjectNotExist that carry no gRPC status.
+func codeFor(err error, kind Kind) codes.Code {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusTooManyRequests:
+			return codes.ResourceExhausted
+		case http.StatusServiceUnavailable, http.StatusBadGateway:
+			return codes.Unavailable
+		case http.StatusGatewayTimeout:
+			return codes.DeadlineExceeded
+		case http.StatusConflict:
+			return codes.Aborted
+		}
+	}
+	code := status.Code(err)
+	if code == codes.Unknown {
+		if fallback, ok := grpcCodes[kind]; ok {
+			return fallback
+		}
+	}
+	return code
+}
+
+func kindFromHTTPStatus(code int) Kind {
+	switch code {
+	case http.StatusNotFound:
+		return NotFound
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return Conflict
+	case http.StatusBadRequest:
+		return Invalid
+	case http.StatusUnauthorized:
+		return Unauthorized
+	case http.StatusForbidden:
+		return Forbidden
+	case http.StatusTooManyRequests:
+		return RateLimited
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return Timeout
+	}
+	return Internal
+}
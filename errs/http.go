@@ -0,0 +1,58 @@
+package errs
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// statusClientClosedRequest is the non-standard status used for requests the client gave up on.
+const statusClientClosedRequest = 499
+
+var httpStatuses = map[Kind]int{
+	NotFound:     http.StatusNotFound,
+	Conflict:     http.StatusConflict,
+	Invalid:      http.StatusBadRequest,
+	Unauthorized: http.StatusUnauthorized,
+	Forbidden:    http.StatusForbidden,
+	RateLimited:  http.StatusTooManyRequests,
+	Canceled:     statusClientClosedRequest,
+	Timeout:      http.StatusGatewayTimeout,
+	Internal:     http.StatusInternalServerError,
+}
+
+// HTTPStatus returns the HTTP status code for err.
+func HTTPStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	return httpStatuses[KindOf(err)]
+}
+
+// ErrorBody is the JSON body written by WriteHTTP.
+type ErrorBody struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail describes an error to API clients.
+type ErrorDetail struct {
+	Code    Kind   `json:"code"`
+	Message string `json:"message"`
+}
+
+// WriteHTTP writes err as a JSON error body with the matching HTTP status code. The messages of
+// internal errors are not exposed to clients. A nil error writes a bare 200 OK.
+func WriteHTTP(w http.ResponseWriter, err error) {
+	if err == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	kind := KindOf(err)
+	message := err.Error()
+	if kind == Internal {
+		message = http.StatusText(http.StatusInternalServerError)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatuses[kind])
+	_ = json.NewEncoder(w).Encode(ErrorBody{Error: ErrorDetail{Code: kind, Message: message}})
+}
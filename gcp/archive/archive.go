@@ -0,0 +1,410 @@
+// Package archive moves old Firestore documents into compressed NDJSON objects in a bucket, and
+// restores them again when they are needed.
+//
+// Each archive run writes its documents to numbered parts under <prefix>/<run ID>/, one document
+// per line in the lossless tagged JSON form of firestore.JSONCodec, alongside a manifest.json
+// recording every part, its checksum and the IDs of the documents it holds.
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	gfirestore "cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/maxcraig112/go-crud/errs"
+	"github.com/maxcraig112/go-crud/gcp/bucket"
+	"github.com/maxcraig112/go-crud/gcp/firestore"
+)
+
+// defaultBatchSize is the number of documents written to each archive part.
+const defaultBatchSize = 1000
+
+// manifestObject is the name of the manifest within an archive run.
+const manifestObject = "manifest.json"
+
+// runIDFormat names archive runs by the time they started. A random suffix is added so runs started
+// within the same second do not share objects.
+const runIDFormat = "20060102T150405Z"
+
+// Archiver archives documents from a store into a bucket.
+type Archiver struct {
+	store  *firestore.GenericStore
+	bucket *bucket.GenericBucket
+	codec  *firestore.JSONCodec
+	prefix string
+}
+
+// NewArchiver returns an archiver moving documents from store into b. Archives are written under
+// "archive/<collection path>" unless changed with WithPrefix.
+func NewArchiver(store *firestore.GenericStore, b *bucket.GenericBucket) *Archiver {
+	return &Archiver{
+		store:  store,
+		bucket: b,
+		codec:  firestore.NewJSONCodec(store.Client(), firestore.JSONOptions{}),
+		prefix: "archive/" + firestore.CollectionPath(store.Collection()),
+	}
+}
+
+// WithPrefix sets the object prefix archive runs are written under.
+func (a *Archiver) WithPrefix(prefix string) *Archiver {
+	a.prefix = prefix
+	return a
+}
+
+// OlderThan returns a query matching documents whose timestamp field is more than age in the past.
+func OlderThan(field string, age time.Duration) []firestore.QueryParameter {
+	return []firestore.QueryParameter{{Path: field, Op: "<", Value: time.Now().Add(-age)}}
+}
+
+// Options controls an archive run.
+type Options struct {
+	// Query selects the documents to archive.
+	Query []firestore.QueryParameter
+	// BatchSize is the number of documents written to each part. Defaults to 1000.
+	BatchSize int
+	// KeepDocuments archives the documents without deleting them from Firestore.
+	KeepDocuments bool
+}
+
+// Manifest describes an archive run.
+type Manifest struct {
+	RunID      string    `json:"runId"`
+	Collection string    `json:"collection"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Parts      []Part    `json:"parts"`
+}
+
+// Part is a single archive object.
+type Part struct {
+	Object string   `json:"object"`
+	SHA256 string   `json:"sha256"`
+	Bytes  int      `json:"bytes"`
+	DocIDs []string `json:"docIds"`
+	// Retained lists the archived documents that were not deleted from Firestore because they
+	// changed after being read.
+	Retained []string `json:"retained,omitempty"`
+}
+
+// Archive moves the documents matching opts.Query into the bucket. Each part is read back and
+// checked against its checksum, and recorded in the manifest, before its documents are deleted.
+// Documents are only deleted if they have not changed since they were archived. The manifest is
+// rewritten after every part, so an interrupted run still describes everything it archived.
+func (a *Archiver) Archive(ctx context.Context, opts Options) (*Manifest, error) {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	now := time.Now().UTC()
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, errs.Wrap(errs.Internal, err, "failed to generate archive run ID")
+	}
+	manifest := &Manifest{
+		RunID:      now.Format(runIDFormat) + "-" + hex.EncodeToString(suffix),
+		Collection: firestore.CollectionPath(a.store.Collection()),
+		CreatedAt:  now,
+	}
+
+	var batch []*gfirestore.DocumentSnapshot
+	flush := func() error {
+		part, err := a.writePart(ctx, manifest, batch)
+		if err != nil {
+			return err
+		}
+		manifest.Parts = append(manifest.Parts, part)
+		if err := a.writeManifest(ctx, manifest); err != nil {
+			return err
+		}
+		docs := batch
+		batch = nil
+		if opts.KeepDocuments {
+			return nil
+		}
+
+		retained, err := a.deleteArchived(ctx, docs)
+		if err != nil || len(retained) == 0 {
+			return err
+		}
+		manifest.Parts[len(manifest.Parts)-1].Retained = retained
+		return a.writeManifest(ctx, manifest)
+	}
+
+	err := a.store.Scan(ctx, firestore.ScanOptions{Query: opts.Query}, func(doc *gfirestore.DocumentSnapshot) error {
+		batch = append(batch, doc)
+		if len(batch) >= batchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return manifest, err
+	}
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			return manifest, err
+		}
+	}
+	return manifest, nil
+}
+
+// writePart uploads docs as a gzip compressed NDJSON object and verifies the upload.
+func (a *Archiver) writePart(ctx context.Context, manifest *Manifest, docs []*gfirestore.DocumentSnapshot) (Part, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	part := Part{
+		Object: fmt.Sprintf("%s/%s/part-%05d.ndjson.gz", a.prefix, manifest.RunID, len(manifest.Parts)+1),
+		DocIDs: make([]string, len(docs)),
+	}
+	for i, doc := range docs {
+		line, err := a.codec.MarshalDoc(doc)
+		if err != nil {
+			return Part{}, err
+		}
+		if _, err := gz.Write(append(line, '\n')); err != nil {
+			return Part{}, err
+		}
+		part.DocIDs[i] = doc.Ref.ID
+	}
+	if err := gz.Close(); err != nil {
+		return Part{}, err
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	part.SHA256 = hex.EncodeToString(sum[:])
+	part.Bytes = buf.Len()
+
+	if err := a.bucket.CreateObject(ctx, part.Object, bytes.NewReader(buf.Bytes())); err != nil {
+		return Part{}, err
+	}
+	if err := a.verifyPart(ctx, part); err != nil {
+		return Part{}, err
+	}
+	return part, nil
+}
+
+// verifyPart reads a part back and checks it matches its checksum and document count.
+func (a *Archiver) verifyPart(ctx context.Context, part Part) error {
+	data, err := a.bucket.GetObject(ctx, part.Object)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != part.SHA256 {
+		return errs.Newf(errs.Internal, "archive object %s does not match its checksum", part.Object)
+	}
+	docs, err := readPart(data)
+	if err != nil {
+		return err
+	}
+	if len(docs) != len(part.DocIDs) {
+		return errs.Newf(errs.Internal, "archive object %s holds %d documents, expected %d", part.Object, len(docs), len(part.DocIDs))
+	}
+	return nil
+}
+
+// deleteArchived deletes docs from Firestore, skipping any changed since they were read. It returns
+// the IDs of the documents that were kept. Any other failure, including an aborted write, is returned.
+func (a *Archiver) deleteArchived(ctx context.Context, docs []*gfirestore.DocumentSnapshot) ([]string, error) {
+	bulkWriter := a.store.Client().BulkWriter(ctx)
+	jobs := make([]*gfirestore.BulkWriterJob, len(docs))
+	for i, doc := range docs {
+		job, err := bulkWriter.Delete(doc.Ref, gfirestore.LastUpdateTime(doc.UpdateTime))
+		if err != nil {
+			bulkWriter.End()
+			return nil, err
+		}
+		jobs[i] = job
+	}
+	bulkWriter.End()
+
+	var retained []string
+	for i, job := range jobs {
+		_, err := job.Results()
+		switch {
+		case err == nil || status.Code(err) == codes.NotFound:
+			// deleted, or already removed by someone else
+		case status.Code(err) == codes.FailedPrecondition:
+			changed, checkErr := changedSince(ctx, docs[i])
+			if checkErr != nil {
+				return nil, checkErr
+			}
+			if !changed {
+				return nil, err
+			}
+			retained = append(retained, docs[i].Ref.ID)
+		default:
+			return nil, err
+		}
+	}
+	return retained, nil
+}
+
+// changedSince reports whether doc was updated after it was read, which is the only failed
+// precondition that means the document should be kept. A document deleted in the meantime has not
+// changed.
+func changedSince(ctx context.Context, doc *gfirestore.DocumentSnapshot) (bool, error) {
+	current, err := doc.Ref.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !current.UpdateTime.Equal(doc.UpdateTime), nil
+}
+
+func (a *Archiver) writeManifest(ctx context.Context, manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return a.bucket.CreateObject(ctx, a.prefix+"/"+manifest.RunID+"/"+manifestObject, bytes.NewReader(data))
+}
+
+// LoadManifest reads the manifest of an archive run.
+func (a *Archiver) LoadManifest(ctx context.Context, runID string) (*Manifest, error) {
+	data, err := a.bucket.GetObject(ctx, a.prefix+"/"+runID+"/"+manifestObject)
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, errs.Wrap(errs.Internal, err, "invalid archive manifest")
+	}
+	return &manifest, nil
+}
+
+// Rehydrate restores archived documents to the store, replacing any documents with the same IDs.
+// If ids is empty every document in the run is restored; otherwise only the named documents are,
+// and only the parts holding them are read. It returns the number of documents restored.
+func (a *Archiver) Rehydrate(ctx context.Context, manifest *Manifest, ids ...string) (int, error) {
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	restored := 0
+	for _, part := range manifest.Parts {
+		if len(wanted) > 0 && !containsAny(part.DocIDs, wanted) {
+			continue
+		}
+		data, err := a.bucket.GetObject(ctx, part.Object)
+		if err != nil {
+			return restored, err
+		}
+		docs, err := readPart(data)
+		if err != nil {
+			return restored, err
+		}
+
+		var refs []*gfirestore.DocumentRef
+		var datas []map[string]interface{}
+		for _, doc := range docs {
+			if len(wanted) > 0 && !wanted[doc.ID] {
+				continue
+			}
+			decoded, err := a.codec.DecodeData(doc.Data)
+			if err != nil {
+				return restored, fmt.Errorf("failed to decode archived document %s: %w", doc.ID, err)
+			}
+			refs = append(refs, a.store.Collection().Doc(doc.ID))
+			datas = append(datas, decoded)
+		}
+		if len(refs) == 0 {
+			continue
+		}
+		n, err := a.restore(ctx, refs, datas)
+		restored += n
+		if err != nil {
+			return restored, err
+		}
+	}
+	return restored, nil
+}
+
+// restore writes archived documents back to Firestore, checking the result of every write. It
+// returns the number of documents written along with the first error.
+func (a *Archiver) restore(ctx context.Context, refs []*gfirestore.DocumentRef, datas []map[string]interface{}) (int, error) {
+	bulkWriter := a.store.Client().BulkWriter(ctx)
+	jobs := make([]*gfirestore.BulkWriterJob, 0, len(refs))
+	var enqueueErr error
+	for i, ref := range refs {
+		job, err := bulkWriter.Set(ref, datas[i])
+		if err != nil {
+			enqueueErr = err
+			break
+		}
+		jobs = append(jobs, job)
+	}
+	bulkWriter.End()
+
+	restored := 0
+	var firstErr error
+	for i, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to restore archived document %s: %w", refs[i].ID, err)
+			}
+			continue
+		}
+		restored++
+	}
+	if firstErr == nil {
+		firstErr = enqueueErr
+	}
+	return restored, firstErr
+}
+
+// archivedDoc is a line of an archive part, keeping the data raw so it can be decoded by the codec.
+type archivedDoc struct {
+	ID   string          `json:"id"`
+	Data json.RawMessage `json:"data"`
+}
+
+// readPart decompresses and parses the documents in an archive part.
+func readPart(data []byte) ([]archivedDoc, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errs.Wrap(errs.Internal, err, "invalid archive object")
+	}
+	defer gz.Close()
+
+	var docs []archivedDoc
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 2*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var doc archivedDoc
+		if err := json.Unmarshal(scanner.Bytes(), &doc); err != nil {
+			return nil, errs.Wrap(errs.Internal, err, "invalid archive object")
+		}
+		docs = append(docs, doc)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errs.Wrap(errs.Internal, err, "invalid archive object")
+	}
+	return docs, nil
+}
+
+func containsAny(ids []string, wanted map[string]bool) bool {
+	for _, id := range ids {
+		if wanted[id] {
+			return true
+		}
+	}
+	return false
+}
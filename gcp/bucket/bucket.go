@@ -12,7 +12,7 @@ const (
 )
 
 type BucketClientConfig struct {
-	BucketName string
+	BucketName string `json:"bucketName"`
 }
 
 func NewBucketClient(ctx context.Context, cfg BucketClientConfig) (*BucketClient, error) {
@@ -10,6 +10,9 @@ import (
 	"cloud.google.com/go/storage"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/iterator"
+
+	"github.com/maxcraig112/go-crud/retry"
+	"github.com/maxcraig112/go-crud/tracing"
 )
 
 type ObjectList []ObjectData
@@ -31,6 +34,8 @@ type ImageData struct {
 }
 type GenericBucket struct {
 	bucket BucketClientInterface
+
+	retryPolicy retry.Policy
 }
 
 func NewGenericBucket(bucket BucketClientInterface) *GenericBucket {
@@ -40,27 +45,57 @@ func NewGenericBucket(bucket BucketClientInterface) *GenericBucket {
 }
 
 // CreateObject uploads a single object and returns its URL.
-func (b *GenericBucket) CreateObject(ctx context.Context, objectName string, data io.Reader) error {
+func (b *GenericBucket) CreateObject(ctx context.Context, objectName string, data io.Reader) (err error) {
+	ctx, done := b.observe(ctx, "CreateObject", tracing.ObjectKey.String(objectName))
+	defer func() { err = done(err) }()
+
+	// uploads can only be retried when the data can be rewound for another attempt
+	seeker, ok := data.(io.Seeker)
+	if !ok {
+		return b.writeObject(ctx, objectName, data)
+	}
+	offset, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return b.writeObject(ctx, objectName, data)
+	}
+
+	attempt := 0
+	err = b.withRetry(ctx, "CreateObject", true, func(ctx context.Context) error {
+		if attempt++; attempt > 1 {
+			if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+				return fmt.Errorf("failed to rewind object data: %w", err)
+			}
+		}
+		return b.writeObject(ctx, objectName, data)
+	})
+	// This is the private URL which we cannot use
+	// url := fmt.Sprintf("https://storage.cloud.google.com/%s/%s", b.bucket.BucketName(), objectName)
+	// This is the public URL
+	// url := fmt.Sprintf("https://storage.googleapis.com/%s/%s", b.bucket.BucketName(), objectName)
+	return err
+}
+
+func (b *GenericBucket) writeObject(ctx context.Context, objectName string, data io.Reader) error {
 	wc := b.bucket.Object(objectName).NewWriter(ctx)
-	if _, err := io.Copy(wc, data); err != nil {
-		err := wc.Close()
-		if err != nil {
-			return fmt.Errorf("failed to close bucket writer: %w", err)
+	n, err := io.Copy(wc, data)
+	tracing.SetAttributes(ctx, tracing.BytesKey.Int64(n))
+	if err != nil {
+		if closeErr := wc.Close(); closeErr != nil {
+			return fmt.Errorf("failed to close bucket writer: %w", closeErr)
 		}
 		return fmt.Errorf("failed to write object: %w", err)
 	}
 	if err := wc.Close(); err != nil {
 		return fmt.Errorf("failed to close writer: %w", err)
 	}
-	// This is the private URL which we cannot use
-	// url := fmt.Sprintf("https://storage.cloud.google.com/%s/%s", b.bucket.BucketName(), objectName)
-	// This is the public URL
-	// url := fmt.Sprintf("https://storage.googleapis.com/%s/%s", b.bucket.BucketName(), objectName)
 	return nil
 }
 
 // CreateObjectsBatch uploads multiple objects and returns their URLs.
-func (b *GenericBucket) CreateObjectsBatch(ctx context.Context, objects ObjectList) (ObjectList, error) {
+func (b *GenericBucket) CreateObjectsBatch(ctx context.Context, objects ObjectList) (_ ObjectList, err error) {
+	ctx, done := b.observe(ctx, "CreateObjectsBatch")
+	defer func() { err = done(err) }()
+
 	objectDatas := make([]ObjectData, len(objects))
 
 	for i := range len(objects) {
@@ -80,15 +115,23 @@ func (b *GenericBucket) CreateObjectsBatch(ctx context.Context, objects ObjectLi
 	return objectDatas, nil
 }
 
-func (b *GenericBucket) DeleteObject(ctx context.Context, objectName string) error {
-	err := b.bucket.Object(objectName).Delete(ctx)
+func (b *GenericBucket) DeleteObject(ctx context.Context, objectName string) (err error) {
+	ctx, done := b.observe(ctx, "DeleteObject", tracing.ObjectKey.String(objectName))
+	defer func() { err = done(err) }()
+
+	err = b.withRetry(ctx, "DeleteObject", true, func(ctx context.Context) error {
+		return b.bucket.Object(objectName).Delete(ctx)
+	})
 	if err != nil {
 		return fmt.Errorf("failed to delete object %s: %w", objectName, err)
 	}
 	return nil
 }
 
-func (b *GenericBucket) DeleteObjectsByPrefix(ctx context.Context, prefix string) error {
+func (b *GenericBucket) DeleteObjectsByPrefix(ctx context.Context, prefix string) (err error) {
+	ctx, done := b.observe(ctx, "DeleteObjectsByPrefix", tracing.ObjectKey.String(prefix))
+	defer func() { err = done(err) }()
+
 	it := b.bucket.Objects(ctx, &storage.Query{Prefix: prefix})
 	for {
 		objAttrs, err := it.Next()
@@ -105,7 +148,23 @@ func (b *GenericBucket) DeleteObjectsByPrefix(ctx context.Context, prefix string
 	return nil
 }
 
-func (b *GenericBucket) GetObject(ctx context.Context, objectName string) ([]byte, error) {
+func (b *GenericBucket) GetObject(ctx context.Context, objectName string) (_ []byte, err error) {
+	ctx, done := b.observe(ctx, "GetObject", tracing.ObjectKey.String(objectName))
+	defer func() { err = done(err) }()
+
+	var data []byte
+	err = b.withRetry(ctx, "GetObject", true, func(ctx context.Context) error {
+		data, err = b.readObject(ctx, objectName)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	tracing.SetAttributes(ctx, tracing.BytesKey.Int(len(data)))
+	return data, nil
+}
+
+func (b *GenericBucket) readObject(ctx context.Context, objectName string) ([]byte, error) {
 	rc, err := b.bucket.Object(objectName).NewReader(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create reader for object %s: %w", objectName, err)
@@ -123,15 +182,25 @@ func (b *GenericBucket) GetObject(ctx context.Context, objectName string) ([]byt
 	return data, nil
 }
 
-func (b *GenericBucket) StreamObject(ctx context.Context, objectName string) (io.ReadCloser, error) {
-	rc, err := b.bucket.Object(objectName).NewReader(ctx)
+func (b *GenericBucket) StreamObject(ctx context.Context, objectName string) (_ io.ReadCloser, err error) {
+	ctx, done := b.observe(ctx, "StreamObject", tracing.ObjectKey.String(objectName))
+	defer func() { err = done(err) }()
+
+	var rc *storage.Reader
+	err = b.withRetry(ctx, "StreamObject", true, func(ctx context.Context) error {
+		rc, err = b.bucket.Object(objectName).NewReader(ctx)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create reader for object %s: %w", objectName, err)
 	}
 	return rc, nil
 }
 
-func (b *GenericBucket) GetSignedURL(ctx context.Context, objectName string) (string, error) {
+func (b *GenericBucket) GetSignedURL(ctx context.Context, objectName string) (_ string, err error) {
+	_, done := b.observe(ctx, "GetSignedURL", tracing.ObjectKey.String(objectName))
+	defer func() { err = done(err) }()
+
 	keyJSON := os.Getenv("BUCKET_JSON_KEY")
 	conf, err := google.JWTConfigFromJSON([]byte(keyJSON))
 	if err != nil {
@@ -0,0 +1,27 @@
+package bucket
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/maxcraig112/go-crud/errs"
+	"github.com/maxcraig112/go-crud/metrics"
+	"github.com/maxcraig112/go-crud/tracing"
+)
+
+// observe starts tracing and metrics instrumentation for a bucket operation. It returns a context
+// carrying the operation's span and a function that must be called with the operation's result,
+// which returns the result converted to a typed errs error.
+func (b *GenericBucket) observe(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, func(error) error) {
+	attrs = append(attrs, tracing.BucketKey.String(b.bucket.BucketName()))
+	ctx, span := tracing.Start(ctx, "bucket", operation, attrs...)
+	start := time.Now()
+	return ctx, func(err error) error {
+		err = errs.From(err)
+		tracing.End(span, err)
+		metrics.ObserveOperation("bucket", operation, b.bucket.BucketName(), start, errs.Code(err).String())
+		return err
+	}
+}
@@ -0,0 +1,19 @@
+package bucket
+
+import (
+	"context"
+
+	"github.com/maxcraig112/go-crud/retry"
+)
+
+// WithRetryPolicy sets the policy used to retry bucket operations that fail with transient errors.
+// By default operations are attempted once.
+func (b *GenericBucket) WithRetryPolicy(policy retry.Policy) *GenericBucket {
+	b.retryPolicy = policy
+	return b
+}
+
+// withRetry runs fn under the bucket's retry policy.
+func (b *GenericBucket) withRetry(ctx context.Context, operation string, idempotent bool, fn func(ctx context.Context) error) error {
+	return b.retryPolicy.Do(ctx, "bucket", operation, idempotent, fn)
+}
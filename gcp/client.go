@@ -2,8 +2,12 @@ package gcp
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"os"
 	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 
@@ -18,6 +22,16 @@ const (
 	USE_FIRESTORE_ENV string = "USE_FIRESTORE"
 	USE_GSM_ENV       string = "USE_GSM"
 	USE_BUCKET_ENV    string = "USE_BUCKET"
+
+	// FIRESTORE_DATABASES_ENV is a comma separated list of named Firestore databases. Each name is
+	// configured from FIRESTORE_<NAME>_PROJECTID, FIRESTORE_<NAME>_DATABASEID and
+	// FIRESTORE_<NAME>_EMULATOR_HOST, where <NAME> is the upper-cased name with dashes replaced by
+	// underscores.
+	FIRESTORE_DATABASES_ENV string = "FIRESTORE_DATABASES"
+	// BUCKETS_ENV is a comma separated list of named buckets, each configured from BUCKET_<NAME>_NAME.
+	BUCKETS_ENV string = "BUCKETS"
+	// CLIENTS_CONFIG_ENV is the path of an optional JSON file configuring named clients.
+	CLIENTS_CONFIG_ENV string = "CLIENTS_CONFIG_FILE"
 )
 
 // ClientOptions should be populated by each service so they specify which clients they intend on using
@@ -29,6 +43,18 @@ type ClientOptions struct {
 
 	FirestoreConfig firestore.FireStoreClientConfig
 	BucketConfig    bucket.BucketClientConfig
+
+	// FirestoreDBs and Buckets configure additional named clients, retrieved with
+	// Clients.FirestoreDB and Clients.BucketNamed. Named clients are created regardless of
+	// UseFirestore and UseBucket.
+	FirestoreDBs map[string]firestore.FireStoreClientConfig
+	Buckets      map[string]bucket.BucketClientConfig
+}
+
+// clientsConfigFile is the format of the file read by LoadClientOptionsFile.
+type clientsConfigFile struct {
+	Firestore map[string]firestore.FireStoreClientConfig `json:"firestore"`
+	Buckets   map[string]bucket.BucketClientConfig       `json:"buckets"`
 }
 
 // Clients holds all external service clients.
@@ -36,17 +62,42 @@ type Clients struct {
 	Firestore firestore.FirestoreClientInterface
 	GSM       gsm.GSMClientInterface
 	Bucket    bucket.BucketClientInterface
+
+	firestoreDBs map[string]firestore.FirestoreClientInterface
+	buckets      map[string]bucket.BucketClientInterface
 }
 
 func getEnvBool(envName string) bool {
 	return os.Getenv(envName) == "true"
 }
-func (c *ClientOptions) LoadClientOptions() {
+
+// getEnvList splits a comma separated environment variable, ignoring empty entries.
+func getEnvList(envName string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(envName), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// envPrefix returns the environment variable prefix for a named client, e.g. FIRESTORE_ANALYTICS_.
+func envPrefix(service, name string) string {
+	return service + "_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+}
+
+// LoadClientOptions configures the options from the environment. Named clients are read from the file
+// named by CLIENTS_CONFIG_FILE first and then from FIRESTORE_DATABASES and BUCKETS. For clients named in
+// both, each environment variable that is set overrides the matching field from the file. An error is
+// returned if CLIENTS_CONFIG_FILE is set but the file cannot be read or parsed.
+func (c *ClientOptions) LoadClientOptions() error {
 	_ = godotenv.Load()
 	c.UseFirestore = getEnvBool(USE_FIRESTORE_ENV)
 	if c.UseFirestore {
 		c.FirestoreConfig.ProjectID = os.Getenv(firestore.PROJECTID_ENV)
 		c.FirestoreConfig.DatabaseID = os.Getenv(firestore.DATABSEID_ENV)
+		c.FirestoreConfig.EmulatorHost = os.Getenv(firestore.EMULATORHOST_ENV)
 	}
 
 	c.UseGSM = getEnvBool(USE_GSM_ENV)
@@ -55,46 +106,166 @@ func (c *ClientOptions) LoadClientOptions() {
 	if c.UseBucket {
 		c.BucketConfig.BucketName = os.Getenv(bucket.BUCKETNAME_ENV)
 	}
+
+	if path := os.Getenv(CLIENTS_CONFIG_ENV); path != "" {
+		if err := c.LoadClientOptionsFile(path); err != nil {
+			return fmt.Errorf("failed to load clients config file %q: %w", path, err)
+		}
+	}
+
+	for _, name := range getEnvList(FIRESTORE_DATABASES_ENV) {
+		prefix := envPrefix("FIRESTORE", name)
+		cfg := c.FirestoreDBs[name]
+		setFromEnv(&cfg.ProjectID, prefix+"PROJECTID")
+		setFromEnv(&cfg.DatabaseID, prefix+"DATABASEID")
+		setFromEnv(&cfg.EmulatorHost, prefix+"EMULATOR_HOST")
+		if cfg.ProjectID == "" {
+			cfg.ProjectID = os.Getenv(firestore.PROJECTID_ENV)
+		}
+		c.setFirestoreDB(name, cfg)
+	}
+
+	for _, name := range getEnvList(BUCKETS_ENV) {
+		cfg := c.Buckets[name]
+		setFromEnv(&cfg.BucketName, envPrefix("BUCKET", name)+"NAME")
+		c.setBucket(name, cfg)
+	}
+	return nil
+}
+
+// setFromEnv overwrites field with the environment variable envName if it is set and not empty.
+func setFromEnv(field *string, envName string) {
+	if v := os.Getenv(envName); v != "" {
+		*field = v
+	}
+}
+
+// LoadClientOptionsFile adds the named clients configured in a JSON file of the form
+//
+//	{
+//	  "firestore": {"analytics": {"projectId": "my-project", "databaseId": "analytics"}},
+//	  "buckets": {"uploads": {"bucketName": "my-project-uploads"}}
+//	}
+//
+// Entries replace named clients of the same name configured before the file is loaded. LoadClientOptions
+// loads the file before reading named clients from the environment, so environment variables that are
+// set win there.
+func (c *ClientOptions) LoadClientOptionsFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var file clientsConfigFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse clients config file: %w", err)
+	}
+	for name, cfg := range file.Firestore {
+		c.setFirestoreDB(name, cfg)
+	}
+	for name, cfg := range file.Buckets {
+		c.setBucket(name, cfg)
+	}
+	return nil
+}
+
+func (c *ClientOptions) setFirestoreDB(name string, cfg firestore.FireStoreClientConfig) {
+	if c.FirestoreDBs == nil {
+		c.FirestoreDBs = make(map[string]firestore.FireStoreClientConfig)
+	}
+	c.FirestoreDBs[name] = cfg
+}
+
+func (c *ClientOptions) setBucket(name string, cfg bucket.BucketClientConfig) {
+	if c.Buckets == nil {
+		c.Buckets = make(map[string]bucket.BucketClientConfig)
+	}
+	c.Buckets[name] = cfg
 }
 
-// InitialiseClients creates and returns all required service clients.
+// InitialiseClients creates and returns all required service clients. If any client fails to
+// initialise, the clients already created are closed.
 func InitialiseClients(ctx context.Context, opts ClientOptions) (*Clients, error) {
-	var firestoreClient *firestore.FirestoreClient
-	var gsmClient *gsm.GSMClient
-	var bucketClient *bucket.BucketClient
-	var err error
+	clients := &Clients{
+		firestoreDBs: make(map[string]firestore.FirestoreClientInterface, len(opts.FirestoreDBs)),
+		buckets:      make(map[string]bucket.BucketClientInterface, len(opts.Buckets)),
+	}
+	fail := func(err error) (*Clients, error) {
+		if closeErr := clients.CloseClients(); closeErr != nil {
+			log.Error().Err(closeErr).Msg("Failed to close clients after initialisation error")
+		}
+		return nil, err
+	}
 
 	if opts.UseFirestore {
 
-		firestoreClient, err = firestore.NewFirestoreClient(ctx, opts.FirestoreConfig)
+		firestoreClient, err := firestore.NewFirestoreClient(ctx, opts.FirestoreConfig)
 		if err != nil {
-			return nil, err
+			return fail(err)
 		}
+		clients.Firestore = firestoreClient
 		log.Info().Msg("Firestore Client Initialised")
 	}
 
 	if opts.UseGSM {
-		gsmClient, err = gsm.NewGSMClient(ctx)
+		gsmClient, err := gsm.NewGSMClient(ctx)
 		if err != nil {
-			return nil, err
+			return fail(err)
 		}
+		clients.GSM = gsmClient
 		log.Info().Msg("GSM Client Initialised")
 	}
 
 	if opts.UseBucket {
-		bucketClient, err = bucket.NewBucketClient(ctx, opts.BucketConfig)
+		bucketClient, err := bucket.NewBucketClient(ctx, opts.BucketConfig)
 		if err != nil {
-			return nil, err
+			return fail(err)
 		}
+		clients.Bucket = bucketClient
 		log.Info().Msg("Bucket Client Initialised")
 	}
 
-	return &Clients{
-		Firestore: firestoreClient,
-		GSM:       gsmClient,
-		Bucket:    bucketClient,
-	}, nil
+	for _, name := range sortedKeys(opts.FirestoreDBs) {
+		client, err := firestore.NewFirestoreClient(ctx, opts.FirestoreDBs[name])
+		if err != nil {
+			return fail(fmt.Errorf("failed to initialise firestore database %q: %w", name, err))
+		}
+		clients.firestoreDBs[name] = client
+		log.Info().Str("name", name).Msg("Firestore Client Initialised")
+	}
+
+	for _, name := range sortedKeys(opts.Buckets) {
+		client, err := bucket.NewBucketClient(ctx, opts.Buckets[name])
+		if err != nil {
+			return fail(fmt.Errorf("failed to initialise bucket %q: %w", name, err))
+		}
+		clients.buckets[name] = client
+		log.Info().Str("name", name).Msg("Bucket Client Initialised")
+	}
+
+	return clients, nil
+
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// FirestoreDB returns the named Firestore client configured in ClientOptions.FirestoreDBs, or nil if
+// no database with that name was configured.
+func (c *Clients) FirestoreDB(name string) firestore.FirestoreClientInterface {
+	return c.firestoreDBs[name]
+}
 
+// BucketNamed returns the named bucket client configured in ClientOptions.Buckets, or nil if no
+// bucket with that name was configured.
+func (c *Clients) BucketNamed(name string) bucket.BucketClientInterface {
+	return c.buckets[name]
 }
 
 // This is used to check if the client is actually in use, because the client
@@ -112,6 +283,13 @@ func (c *Clients) CloseClients() error {
 	}
 	log.Info().Msg("Firestore Client Closed")
 
+	for _, name := range sortedKeys(c.firestoreDBs) {
+		if err := c.firestoreDBs[name].Close(); err != nil {
+			return err
+		}
+		log.Info().Str("name", name).Msg("Firestore Client Closed")
+	}
+
 	if !isNil(c.GSM) {
 		err := c.GSM.Close()
 		if err != nil {
@@ -128,5 +306,12 @@ func (c *Clients) CloseClients() error {
 	}
 	log.Info().Msg("Bucket Client Closed")
 
+	for _, name := range sortedKeys(c.buckets) {
+		if err := c.buckets[name].Close(); err != nil {
+			return err
+		}
+		log.Info().Str("name", name).Msg("Bucket Client Closed")
+	}
+
 	return nil
 }
@@ -0,0 +1,371 @@
+package firestore
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+
+	"github.com/maxcraig112/go-crud/errs"
+	"github.com/maxcraig112/go-crud/tracing"
+)
+
+// CopyTransform modifies a document's data before it is written to the destination. Returning nil
+// data skips the document, along with its subcollections.
+type CopyTransform func(ctx context.Context, src *firestore.DocumentSnapshot, data map[string]interface{}) (map[string]interface{}, error)
+
+// CopyCheckpoint stores the progress of a copy so an interrupted copy can resume where it stopped.
+type CopyCheckpoint interface {
+	// Load returns the ID of the last source document copied, or "" to start from the beginning.
+	Load(ctx context.Context) (string, error)
+	// Save records that every source document up to and including lastID has been copied.
+	Save(ctx context.Context, lastID string) error
+}
+
+// CopyOptions controls CopyTo.
+type CopyOptions struct {
+	// Query restricts the copy to matching top-level documents. Subcollections are copied whole.
+	Query []QueryParameter
+	// Recursive also copies every document's subcollections.
+	Recursive bool
+	// MapID returns the destination ID for a source document in the collection at collectionPath,
+	// relative to the source database. Nil keeps the source IDs. It is also applied to the IDs in
+	// document references, so references keep pointing at the copied documents.
+	MapID func(collectionPath, id string) string
+	// Transform is applied to every document before it is written.
+	Transform CopyTransform
+	// Checkpoint records progress after every page of top-level documents and is used to resume.
+	Checkpoint CopyCheckpoint
+	// DryRun writes nothing. Instead, each document is compared against the destination and the
+	// differences are reported in CopyResult.Diffs.
+	DryRun bool
+	// PageSize is the number of top-level documents read and written per batch. Defaults to 500.
+	PageSize int
+}
+
+// CopyAction describes what copying a document does to the destination.
+type CopyAction string
+
+const (
+	CopyCreate    CopyAction = "create"
+	CopyUpdate    CopyAction = "update"
+	CopyUnchanged CopyAction = "unchanged"
+)
+
+// CopyDiff is the change copying a document would make to the destination.
+type CopyDiff struct {
+	// Path is the destination document's path relative to its database.
+	Path   string
+	Action CopyAction
+	// Fields lists the changed field paths of an update.
+	Fields []string
+}
+
+// CopyResult reports the outcome of CopyTo.
+type CopyResult struct {
+	// Copied counts the documents written, or that would be written in a dry run, including
+	// subcollection documents.
+	Copied int
+	// Skipped counts the documents the transform skipped.
+	Skipped int
+	// Diffs is only populated in a dry run.
+	Diffs []CopyDiff
+	// LastID is the ID of the last top-level source document processed.
+	LastID string
+}
+
+// CopyTo copies the store's documents into dest, which may be backed by a different project or
+// database. Documents are written with Set, replacing existing destination documents, so repeating a
+// copy syncs the destination with the source. Document references are rewritten to point at the
+// same paths in the destination database, with their IDs mapped by MapID.
+func (s *GenericStore) CopyTo(ctx context.Context, dest *GenericStore, opts CopyOptions) (_ *CopyResult, err error) {
+	ctx, done := s.observe(ctx, "CopyTo", tracing.QueryKey.String(queryShape(opts.Query)))
+	defer func() { err = done(err) }()
+
+	c := &copier{opts: opts, result: &CopyResult{}}
+
+	scan := ScanOptions{Query: opts.Query, PageSize: opts.PageSize}
+	if opts.Checkpoint != nil {
+		if scan.StartAfter, err = opts.Checkpoint.Load(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if scan.PageSize <= 0 {
+		scan.PageSize = defaultScanPageSize
+	}
+
+	var page []*firestore.DocumentSnapshot
+	flush := func() error {
+		if err := c.copyDocs(ctx, s, dest, page); err != nil {
+			return err
+		}
+		c.result.LastID = page[len(page)-1].Ref.ID
+		page = page[:0]
+		if opts.Checkpoint != nil && !opts.DryRun {
+			return opts.Checkpoint.Save(ctx, c.result.LastID)
+		}
+		return nil
+	}
+
+	err = s.Scan(ctx, scan, func(doc *firestore.DocumentSnapshot) error {
+		page = append(page, doc)
+		if len(page) >= scan.PageSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(page) > 0 {
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+	return c.result, nil
+}
+
+type copier struct {
+	opts   CopyOptions
+	result *CopyResult
+}
+
+// copyDocs copies a batch of documents from src into dest, followed by their subcollections.
+func (c *copier) copyDocs(ctx context.Context, src, dest *GenericStore, docs []*firestore.DocumentSnapshot) error {
+	refs := make([]*firestore.DocumentRef, 0, len(docs))
+	var srcs []*firestore.DocumentSnapshot
+	var datas []map[string]interface{}
+
+	for _, doc := range docs {
+		data := rebaseRefs(doc.Data(), dest.client, c.opts.MapID).(map[string]interface{})
+		if c.opts.Transform != nil {
+			var err error
+			if data, err = c.opts.Transform(ctx, doc, data); err != nil {
+				return err
+			}
+			if data == nil {
+				c.result.Skipped++
+				continue
+			}
+		}
+
+		id := doc.Ref.ID
+		if c.opts.MapID != nil {
+			id = c.opts.MapID(CollectionPath(doc.Ref.Parent), id)
+		}
+		refs = append(refs, dest.collection.Doc(id))
+		srcs = append(srcs, doc)
+		datas = append(datas, data)
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+
+	var err error
+	if c.opts.DryRun {
+		err = c.diffDocs(ctx, dest, refs, datas)
+	} else {
+		err = c.writeDocs(ctx, dest, refs, datas)
+	}
+	if err != nil {
+		return err
+	}
+	c.result.Copied += len(refs)
+
+	if !c.opts.Recursive {
+		return nil
+	}
+	for i, doc := range srcs {
+		if err := c.copySubcollections(ctx, src.sub(doc.Ref), dest.sub(refs[i]), doc.Ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copySubcollections copies every subcollection of the source document ref into the matching
+// subcollection of the destination document.
+func (c *copier) copySubcollections(ctx context.Context, src, dest func(collectionID string) *GenericStore, ref *firestore.DocumentRef) error {
+	collections, err := ref.Collections(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	for _, coll := range collections {
+		source, target := src(coll.ID), dest(coll.ID)
+		err := source.scanPages(ctx, func(docs []*firestore.DocumentSnapshot) error {
+			return c.copyDocs(ctx, source, target, docs)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanPages streams every document in the collection in pages of defaultScanPageSize.
+func (s *GenericStore) scanPages(ctx context.Context, fn func([]*firestore.DocumentSnapshot) error) error {
+	var page []*firestore.DocumentSnapshot
+	err := s.Scan(ctx, ScanOptions{}, func(doc *firestore.DocumentSnapshot) error {
+		page = append(page, doc)
+		if len(page) < defaultScanPageSize {
+			return nil
+		}
+		err := fn(page)
+		page = nil
+		return err
+	})
+	if err != nil || len(page) == 0 {
+		return err
+	}
+	return fn(page)
+}
+
+// sub returns a function creating stores for the subcollections of ref, sharing the store's client
+// and retry policy.
+func (s *GenericStore) sub(ref *firestore.DocumentRef) func(collectionID string) *GenericStore {
+	return func(collectionID string) *GenericStore {
+		return &GenericStore{client: s.client, collection: ref.Collection(collectionID), retryPolicy: s.retryPolicy}
+	}
+}
+
+func (c *copier) writeDocs(ctx context.Context, dest *GenericStore, refs []*firestore.DocumentRef, datas []map[string]interface{}) error {
+	bulkWriter := dest.client.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, len(refs))
+	for i, ref := range refs {
+		job, err := bulkWriter.Set(ref, datas[i])
+		if err != nil {
+			bulkWriter.End()
+			return err
+		}
+		jobs[i] = job
+	}
+	bulkWriter.End()
+
+	for i, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return errs.Wrap(errs.KindOf(err), err, "failed to copy "+relativePath(refs[i]))
+		}
+	}
+	return nil
+}
+
+// diffDocs compares the documents that would be written against the destination.
+func (c *copier) diffDocs(ctx context.Context, dest *GenericStore, refs []*firestore.DocumentRef, datas []map[string]interface{}) error {
+	var existing []*firestore.DocumentSnapshot
+	err := dest.withRetry(ctx, "CopyTo", true, func(ctx context.Context) error {
+		var err error
+		existing, err = dest.client.GetAll(ctx, refs)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, snap := range existing {
+		diff := CopyDiff{Path: relativePath(refs[i])}
+		if !snap.Exists() {
+			diff.Action = CopyCreate
+			c.result.Diffs = append(c.result.Diffs, diff)
+			continue
+		}
+
+		var updates []firestore.Update
+		diffValue(nil, reflect.ValueOf(refsToPaths(snap.Data())), reflect.ValueOf(refsToPaths(datas[i])), &updates)
+		diff.Action = CopyUnchanged
+		if len(updates) > 0 {
+			diff.Action = CopyUpdate
+			for _, u := range updates {
+				diff.Fields = append(diff.Fields, strings.Join(u.FieldPath, "."))
+			}
+		}
+		c.result.Diffs = append(c.result.Diffs, diff)
+	}
+	return nil
+}
+
+// rebaseRefs returns a copy of value with every document reference rewritten to the same path in
+// client's database, as Firestore rejects references to other databases. If mapID is set, the IDs
+// along each reference's path are mapped the same way copied documents are.
+func rebaseRefs(value interface{}, client FirestoreClientInterface, mapID func(collectionPath, id string) string) interface{} {
+	switch v := value.(type) {
+	case *firestore.DocumentRef:
+		if v == nil {
+			return v
+		}
+		path := relativePath(v)
+		if mapID != nil {
+			path = mapPath(path, mapID)
+		}
+		return docRefAt(client, path)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = rebaseRefs(item, client, mapID)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = rebaseRefs(item, client, mapID)
+		}
+		return out
+	}
+	return value
+}
+
+// mapPath applies mapID to every document ID in a document path relative to the database, passing
+// the source path of the collection each ID belongs to.
+func mapPath(path string, mapID func(collectionPath, id string) string) string {
+	segments := strings.Split(path, "/")
+	mapped := make([]string, len(segments))
+	copy(mapped, segments)
+	for i := 1; i < len(segments); i += 2 {
+		mapped[i] = mapID(strings.Join(segments[:i], "/"), segments[i])
+	}
+	return strings.Join(mapped, "/")
+}
+
+// refsToPaths returns a copy of value with document references replaced by their relative paths,
+// so references from different clients can be compared.
+func refsToPaths(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *firestore.DocumentRef:
+		if v == nil {
+			return v
+		}
+		return "ref:" + relativePath(v)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = refsToPaths(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = refsToPaths(item)
+		}
+		return out
+	}
+	return value
+}
+
+// FileCheckpoint stores copy progress in a local file.
+type FileCheckpoint string
+
+func (f FileCheckpoint) Load(ctx context.Context) (string, error) {
+	data, err := os.ReadFile(string(f))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (f FileCheckpoint) Save(ctx context.Context, lastID string) error {
+	return os.WriteFile(string(f), []byte(lastID+"\n"), 0o644)
+}
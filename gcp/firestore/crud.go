@@ -2,12 +2,17 @@ package firestore
 
 import (
 	"context"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/firestore/apiv1/firestorepb"
 	"google.golang.org/api/iterator"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+
+	"github.com/maxcraig112/go-crud/errs"
+	"github.com/maxcraig112/go-crud/retry"
+	"github.com/maxcraig112/go-crud/tracing"
 )
 
 type QueryParameter struct {
@@ -20,9 +25,26 @@ type Aggregation string
 
 var Count Aggregation = "count"
 
+// maxGetAllBatchSize is the number of documents requested per GetAll round trip.
+const maxGetAllBatchSize = 100
+
 type GenericStore struct {
 	client     FirestoreClientInterface
 	collection *firestore.CollectionRef
+	relations  map[string]*GenericStore
+
+	retryPolicy retry.Policy
+	idGenerator IDGenerator
+
+	indexRegistry *IndexRegistry
+
+	queryProfiler      QueryProfiler
+	slowQueryThreshold time.Duration
+
+	ttl *TTLConfig
+
+	// geoIndexes maps geopoint fields to the fields holding their geohashes
+	geoIndexes map[string]string
 }
 
 func NewGenericStore(client FirestoreClientInterface, collectionID string) *GenericStore {
@@ -32,45 +54,106 @@ func NewGenericStore(client FirestoreClientInterface, collectionID string) *Gene
 // Client exposes the underlying Firestore client interface for advanced operations.
 func (s *GenericStore) Client() FirestoreClientInterface { return s.client }
 
-func (s *GenericStore) CreateDoc(ctx context.Context, data interface{}) (string, error) {
-	docRef, _, err := s.collection.Add(ctx, data)
+// Collection exposes the collection the store reads and writes.
+func (s *GenericStore) Collection() *firestore.CollectionRef { return s.collection }
+
+func (s *GenericStore) CreateDoc(ctx context.Context, data interface{}) (_ string, err error) {
+	ctx, done := s.observe(ctx, "CreateDoc")
+	defer func() { err = done(err) }()
+
+	if data, err = s.withGeohashes(data); err != nil {
+		return "", err
+	}
+
+	if s.idGenerator != nil {
+		return s.createWithGeneratedID(withReservedIDs(ctx, make(map[string]bool)), data)
+	}
+
+	var docRef *firestore.DocumentRef
+	err = s.withRetry(ctx, "CreateDoc", false, func(ctx context.Context) error {
+		docRef, _, err = s.collection.Add(ctx, data)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
 	return docRef.ID, nil
 }
 
-func (s *GenericStore) CreateDocsBatch(ctx context.Context, docs []interface{}, ids []string) ([]string, error) {
+// createWithGeneratedID creates data under a generated ID. A generator can only check an ID before it
+// is written, so when Create finds the ID taken another ID is generated with the taken one reserved.
+func (s *GenericStore) createWithGeneratedID(ctx context.Context, data interface{}) (string, error) {
+	for attempt := 0; attempt < maxCreateAttempts; attempt++ {
+		id, err := s.newID(ctx, data)
+		if err != nil {
+			return "", err
+		}
+		err = s.withRetry(ctx, "CreateDoc", false, func(ctx context.Context) error {
+			_, err := s.collection.Doc(id).Create(ctx, data)
+			return err
+		})
+		if status.Code(err) == codes.AlreadyExists {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return id, nil
+	}
+	return "", ErrAlreadyExists
+}
+
+func (s *GenericStore) CreateDocsBatch(ctx context.Context, docs []interface{}, ids []string) (_ []string, err error) {
+	ctx, done := s.observe(ctx, "CreateDocsBatch", tracing.DocCountKey.Int(len(docs)))
+	defer func() { err = done(err) }()
+
 	// If caller provided IDs, length must match docs
 	if len(ids) != 0 && len(ids) != len(docs) {
-		return nil, status.Error(codes.InvalidArgument, "number of ids and documents does not match")
+		return nil, errs.New(errs.Invalid, "number of ids and documents does not match")
 	}
 
-	// If no IDs provided, generate them
-	if len(ids) == 0 {
+	// If no IDs provided, generate them. Generated IDs are written with Create so a document is never
+	// overwritten, and are reserved so a generator cannot hand out the same ID twice in one batch.
+	generated := len(ids) == 0
+	if generated {
+		ctx = withReservedIDs(ctx, make(map[string]bool, len(docs)))
 		ids = make([]string, len(docs))
 		for i := range docs {
-			ids[i] = s.collection.NewDoc().ID
+			if ids[i], err = s.newID(ctx, docs[i]); err != nil {
+				return nil, err
+			}
 		}
 	}
 
 	bulkWriter := s.client.BulkWriter(ctx)
-	errChan := make(chan error, len(docs))
-
+	prepared := make([]interface{}, len(docs))
+	jobs := make([]*firestore.BulkWriterJob, len(docs))
 	for i, data := range docs {
+		if prepared[i], err = s.withGeohashes(data); err != nil {
+			bulkWriter.End()
+			return nil, err
+		}
 		docRef := s.collection.Doc(ids[i]) // use provided or generated ID
-		_, err := bulkWriter.Set(docRef, data)
+		if generated {
+			jobs[i], err = bulkWriter.Create(docRef, prepared[i])
+		} else {
+			jobs[i], err = bulkWriter.Set(docRef, prepared[i])
+		}
 		if err != nil {
-			errChan <- err
+			bulkWriter.End()
+			return nil, err
 		}
 	}
 
 	// Finalize writes
 	bulkWriter.End()
 
-	// Check for errors
-	close(errChan)
-	for err := range errChan {
+	// Check every write, giving documents whose generated ID was taken in the meantime a new one
+	for i, job := range jobs {
+		_, err := job.Results()
+		if generated && status.Code(err) == codes.AlreadyExists {
+			ids[i], err = s.createWithGeneratedID(ctx, prepared[i])
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -79,60 +162,180 @@ func (s *GenericStore) CreateDocsBatch(ctx context.Context, docs []interface{},
 	return ids, nil
 }
 
-func (s *GenericStore) ReadCollection(ctx context.Context, query []QueryParameter) ([]*firestore.DocumentSnapshot, error) {
-	result := s.collection.Query
+// SetDocsBatch writes docs with the given IDs, replacing any existing documents, so writing the same
+// batch again leaves the data unchanged. Writes that fail in the bulk writer are retried individually
+// under the store's retry policy.
+func (s *GenericStore) SetDocsBatch(ctx context.Context, docs []interface{}, ids []string) (err error) {
+	ctx, done := s.observe(ctx, "SetDocsBatch", tracing.DocCountKey.Int(len(docs)))
+	defer func() { err = done(err) }()
+
+	if len(ids) != len(docs) {
+		return errs.New(errs.Invalid, "number of ids and documents does not match")
+	}
+
+	bulkWriter := s.client.BulkWriter(ctx)
+	prepared := make([]interface{}, len(docs))
+	jobs := make([]*firestore.BulkWriterJob, len(docs))
+	for i, data := range docs {
+		if prepared[i], err = s.withGeohashes(data); err != nil {
+			bulkWriter.End()
+			return errs.Wrap(errs.KindOf(err), err, "failed to set document "+ids[i])
+		}
+		if jobs[i], err = bulkWriter.Set(s.collection.Doc(ids[i]), prepared[i]); err != nil {
+			bulkWriter.End()
+			return errs.Wrap(errs.KindOf(err), err, "failed to set document "+ids[i])
+		}
+	}
+	bulkWriter.End()
 
-	for _, q := range query {
-		result = result.Where(q.Path, q.Op, q.Value)
+	for i, job := range jobs {
+		if _, err := job.Results(); err == nil {
+			continue
+		}
+		err := s.withRetry(ctx, "SetDocsBatch", true, func(ctx context.Context) error {
+			_, err := s.collection.Doc(ids[i]).Set(ctx, prepared[i])
+			return err
+		})
+		if err != nil {
+			return errs.Wrap(errs.KindOf(err), err, "failed to set document "+ids[i])
+		}
 	}
-	iter := result.Documents(ctx)
-	defer iter.Stop()
-	docs, err := iter.GetAll()
+	return nil
+}
+
+func (s *GenericStore) ReadCollection(ctx context.Context, query []QueryParameter) (_ []*firestore.DocumentSnapshot, err error) {
+	ctx, done := s.observe(ctx, "ReadCollection", tracing.QueryKey.String(queryShape(query)))
+	defer func() { err = done(err) }()
+
+	s.trackQuery(query)
+
+	start := time.Now()
+	var docs []*firestore.DocumentSnapshot
+	var metrics *firestore.ExplainMetrics
+	err = s.withRetry(ctx, "ReadCollection", true, func(ctx context.Context) error {
+		docs, metrics, err = s.getAll(ctx, s.buildQuery(ctx, query), s.explainOptions())
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return nil, s.indexError(query, err)
 	}
+	s.profileQuery(ctx, "ReadCollection", query, start, len(docs), metrics)
 
-	return docs, nil
+	return s.filterExpired(ctx, docs), nil
 }
 
-func (s *GenericStore) GetAggregationWithQuery(ctx context.Context, query []QueryParameter, aggregation Aggregation) (int64, error) {
-	result := s.collection.Query
+func (s *GenericStore) GetAggregationWithQuery(ctx context.Context, query []QueryParameter, aggregation Aggregation) (_ int64, err error) {
+	ctx, done := s.observe(ctx, "GetAggregationWithQuery", tracing.QueryKey.String(queryShape(query)))
+	defer func() { err = done(err) }()
 
-	for _, q := range query {
-		result = result.Where(q.Path, q.Op, q.Value)
+	result := s.buildQuery(ctx, query)
+	if explain := s.explainOptions(); explain != nil {
+		result = result.WithRunOptions(*explain)
 	}
 
+	s.trackQuery(query)
+
 	var aggregationQuery *firestore.AggregationQuery
 	switch aggregation {
 	case Count:
 		aggregationQuery = result.NewAggregationQuery().WithCount(string(aggregation))
 	default:
-		return 0, status.Errorf(codes.InvalidArgument, "unsupported aggregation: %s", aggregation)
+		return 0, errs.Newf(errs.Invalid, "unsupported aggregation: %s", aggregation)
+	}
+
+	if _, ok := ReadTimeFrom(ctx); ok || s.ttl != nil {
+		var count int64
+		err = s.withRetry(ctx, "GetAggregationWithQuery", true, func(ctx context.Context) error {
+			count, err = s.countDocs(ctx, result)
+			return err
+		})
+		if err != nil {
+			return 0, s.indexError(query, err)
+		}
+		return count, nil
 	}
 
-	aggResult, err := aggregationQuery.Get(ctx)
+	start := time.Now()
+	var aggResponse *firestore.AggregationResponse
+	err = s.withRetry(ctx, "GetAggregationWithQuery", true, func(ctx context.Context) error {
+		aggResponse, err = aggregationQuery.GetResponse(ctx)
+		return err
+	})
 	if err != nil {
-		return 0, err
+		return 0, s.indexError(query, err)
 	}
+	s.profileQuery(ctx, "GetAggregationWithQuery", query, start, 1, aggResponse.ExplainMetrics)
 
-	count, ok := aggResult[string(aggregation)]
+	count, ok := aggResponse.Result[string(aggregation)]
 	if !ok {
-		return 0, status.Errorf(codes.Internal, "aggregation result missing count value")
+		return 0, errs.New(errs.Internal, "aggregation result missing count value")
 	}
 	countValue := count.(*firestorepb.Value)
 	return countValue.GetIntegerValue(), nil
 }
 
-func (s *GenericStore) GetDoc(ctx context.Context, docID string) (*firestore.DocumentSnapshot, error) {
-	docSnap, err := s.collection.Doc(docID).Get(ctx)
-	if status.Code(err) == codes.NotFound {
+func (s *GenericStore) GetDoc(ctx context.Context, docID string) (_ *firestore.DocumentSnapshot, err error) {
+	ctx, done := s.observe(ctx, "GetDoc", tracing.DocIDKey.String(docID))
+	defer func() { err = done(err) }()
+
+	var docSnap *firestore.DocumentSnapshot
+	err = s.withRetry(ctx, "GetDoc", true, func(ctx context.Context) error {
+		docSnap, err = s.docRef(ctx, docID).Get(ctx)
+		return err
+	})
+	if status.Code(err) == codes.NotFound || (err == nil && s.isExpired(docSnap, s.expiryTime(ctx))) {
 		return nil, ErrNotFound
 	}
 	return docSnap, err
 }
 
+// GetDocs fetches the documents with the given IDs in batched round trips. The returned snapshots
+// are in the same order as ids, with a nil entry for every document that does not exist or has expired.
+// The IDs of missing documents are also returned separately.
+func (s *GenericStore) GetDocs(ctx context.Context, ids []string) (_ []*firestore.DocumentSnapshot, _ []string, err error) {
+	ctx, done := s.observe(ctx, "GetDocs", tracing.DocCountKey.Int(len(ids)))
+	defer func() { err = done(err) }()
+
+	docs := make([]*firestore.DocumentSnapshot, len(ids))
+	var missing []string
+
+	for start := 0; start < len(ids); start += maxGetAllBatchSize {
+		end := min(start+maxGetAllBatchSize, len(ids))
+
+		refs := make([]*firestore.DocumentRef, end-start)
+		for i, id := range ids[start:end] {
+			refs[i] = s.collection.Doc(id)
+		}
+
+		var snaps []*firestore.DocumentSnapshot
+		err = s.withRetry(ctx, "GetDocs", true, func(ctx context.Context) error {
+			if _, ok := ReadTimeFrom(ctx); ok {
+				snaps, err = s.getAllAt(ctx, refs)
+			} else {
+				snaps, err = s.client.GetAll(ctx, refs)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		now := s.expiryTime(ctx)
+		for i, snap := range snaps {
+			if !snap.Exists() || s.isExpired(snap, now) {
+				missing = append(missing, ids[start+i])
+				continue
+			}
+			docs[start+i] = snap
+		}
+	}
+	return docs, missing, nil
+}
+
 // GetDocByQuery returns a single document matching the query. Returns ErrNotFound if none, or error if not unique.
-func (s *GenericStore) GetDocByQuery(ctx context.Context, query []QueryParameter) (*firestore.DocumentSnapshot, error) {
+func (s *GenericStore) GetDocByQuery(ctx context.Context, query []QueryParameter) (_ *firestore.DocumentSnapshot, err error) {
+	ctx, done := s.observe(ctx, "GetDocByQuery", tracing.QueryKey.String(queryShape(query)))
+	defer func() { err = done(err) }()
+
 	docs, err := s.ReadCollection(ctx, query)
 	if err != nil {
 		return nil, err
@@ -141,20 +344,29 @@ func (s *GenericStore) GetDocByQuery(ctx context.Context, query []QueryParameter
 		return nil, ErrNotFound
 	}
 	if len(docs) > 1 {
-		return nil, status.Error(codes.FailedPrecondition, "query did not resolve to a unique document")
+		return nil, errs.New(errs.Conflict, "query did not resolve to a unique document")
 	}
 	return docs[0], nil
 }
 
-func (s *GenericStore) DeleteDoc(ctx context.Context, docID string) error {
-	_, err := s.collection.Doc(docID).Delete(ctx)
+func (s *GenericStore) DeleteDoc(ctx context.Context, docID string) (err error) {
+	ctx, done := s.observe(ctx, "DeleteDoc", tracing.DocIDKey.String(docID))
+	defer func() { err = done(err) }()
+
+	err = s.withRetry(ctx, "DeleteDoc", true, func(ctx context.Context) error {
+		_, err := s.collection.Doc(docID).Delete(ctx)
+		return err
+	})
 	if status.Code(err) == codes.NotFound {
 		return ErrNotFound
 	}
 	return err
 }
 
-func (s *GenericStore) DeleteDocByQuery(ctx context.Context, query []QueryParameter) error {
+func (s *GenericStore) DeleteDocByQuery(ctx context.Context, query []QueryParameter) (err error) {
+	ctx, done := s.observe(ctx, "DeleteDocByQuery", tracing.QueryKey.String(queryShape(query)))
+	defer func() { err = done(err) }()
+
 	docs, err := s.ReadCollection(ctx, query)
 	if err != nil {
 		return err
@@ -163,13 +375,18 @@ func (s *GenericStore) DeleteDocByQuery(ctx context.Context, query []QueryParame
 		return ErrNotFound
 	}
 	if len(docs) > 1 {
-		return status.Error(codes.FailedPrecondition, "query did not resolve to a unique document for delete")
+		return errs.New(errs.Conflict, "query did not resolve to a unique document for delete")
 	}
-	_, err = docs[0].Ref.Delete(ctx)
-	return err
+	return s.withRetry(ctx, "DeleteDocByQuery", true, func(ctx context.Context) error {
+		_, err := docs[0].Ref.Delete(ctx)
+		return err
+	})
 }
 
-func (s *GenericStore) DeleteDocsByQuery(ctx context.Context, query []QueryParameter) error {
+func (s *GenericStore) DeleteDocsByQuery(ctx context.Context, query []QueryParameter) (err error) {
+	ctx, done := s.observe(ctx, "DeleteDocsByQuery", tracing.QueryKey.String(queryShape(query)))
+	defer func() { err = done(err) }()
+
 	docs, err := s.ReadCollection(ctx, query)
 	if err != nil {
 		return err
@@ -190,20 +407,217 @@ func (s *GenericStore) DeleteDocsByQuery(ctx context.Context, query []QueryParam
 	return nil
 }
 
-func (s *GenericStore) UpdateDoc(ctx context.Context, docID string, updateParams []firestore.Update) error {
+// RecursiveDeleteOptions controls how DeleteDocRecursive walks nested subcollections.
+type RecursiveDeleteOptions struct {
+	// DryRun counts the documents that would be removed without deleting anything.
+	DryRun bool
+	// MaxDepth limits how many levels of subcollections are walked. Zero means no limit. If documents
+	// exist below the limit nothing is deleted and a Conflict error is returned, as deleting their
+	// parents would leave them unreachable.
+	MaxDepth int
+}
+
+// DeleteDocRecursive deletes a document along with every document in its nested subcollections.
+// Documents are deleted bottom-up, deepest level first, so no orphaned subcollections are left
+// behind. It returns the number of documents removed, or that would be removed when DryRun is set.
+// If a delete fails part way through, the number of documents already removed is returned with the
+// error.
+//
+// A document that does not exist but still has subcollections is deliberately treated as a tree to
+// clean up: its subcollection documents are deleted and counted, and the missing document itself is
+// not. ErrNotFound is only returned when there is nothing to delete.
+func (s *GenericStore) DeleteDocRecursive(ctx context.Context, docID string, opts RecursiveDeleteOptions) (_ int, err error) {
+	ctx, done := s.observe(ctx, "DeleteDocRecursive", tracing.DocIDKey.String(docID))
+	defer func() { err = done(err) }()
+
+	docRef := s.collection.Doc(docID)
+
+	// levels[i] holds every document reference found i levels below the root document
+	var levels [][]*firestore.DocumentRef
+	if err := collectSubcollectionDocs(ctx, docRef, 1, opts.MaxDepth, &levels); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, level := range levels {
+		count += len(level)
+	}
+	_, err = docRef.Get(ctx)
+	rootExists := status.Code(err) != codes.NotFound
+	if rootExists && err != nil {
+		return 0, err
+	}
+	if rootExists {
+		count++
+	} else if count == 0 {
+		return 0, ErrNotFound
+	}
+	if opts.DryRun {
+		return count, nil
+	}
+
+	bulkWriter := s.client.BulkWriter(ctx)
+	defer bulkWriter.End()
+
+	deleted := 0
+	for i := len(levels) - 1; i >= 0; i-- {
+		n, err := deleteRefs(bulkWriter, levels[i])
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	if !rootExists {
+		return deleted, nil
+	}
+	n, err := deleteRefs(bulkWriter, []*firestore.DocumentRef{docRef})
+	return deleted + n, err
+}
+
+// collectSubcollectionDocs appends every document beneath docRef to levels, grouped by depth.
+// It returns a Conflict error if documents exist below maxDepth.
+func collectSubcollectionDocs(ctx context.Context, docRef *firestore.DocumentRef, depth, maxDepth int, levels *[][]*firestore.DocumentRef) error {
+	collections, err := docRef.Collections(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, coll := range collections {
+		refs, err := coll.DocumentRefs(ctx).GetAll()
+		if err != nil {
+			return err
+		}
+		if len(refs) == 0 {
+			continue
+		}
+		if maxDepth > 0 && depth > maxDepth {
+			return errs.Newf(errs.Conflict, "%s has documents below the maximum depth of %d", relativePath(docRef), maxDepth)
+		}
+		if len(*levels) < depth {
+			*levels = append(*levels, nil)
+		}
+		(*levels)[depth-1] = append((*levels)[depth-1], refs...)
+
+		for _, ref := range refs {
+			if err := collectSubcollectionDocs(ctx, ref, depth+1, maxDepth, levels); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteRefs enqueues a delete for every reference and waits for them all to be committed. It returns
+// the number of documents deleted, which is still reported when some deletes fail.
+func deleteRefs(bulkWriter *firestore.BulkWriter, refs []*firestore.DocumentRef) (int, error) {
+	jobs := make([]*firestore.BulkWriterJob, 0, len(refs))
+	var enqueueErr error
+	for _, ref := range refs {
+		job, err := bulkWriter.Delete(ref)
+		if err != nil {
+			enqueueErr = err
+			break
+		}
+		jobs = append(jobs, job)
+	}
+	bulkWriter.Flush()
+
+	deleted := 0
+	var firstErr error
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		deleted++
+	}
+	if firstErr == nil {
+		firstErr = enqueueErr
+	}
+	return deleted, firstErr
+}
+
+func (s *GenericStore) UpdateDoc(ctx context.Context, docID string, updateParams []firestore.Update) (err error) {
+	ctx, done := s.observe(ctx, "UpdateDoc", tracing.DocIDKey.String(docID))
+	defer func() { err = done(err) }()
+
 	// Convert updateParameters into firestore.Update
 	// this struct is not even be needed but I like it
+	if updateParams, err = s.geohashUpdates(updateParams); err != nil {
+		return err
+	}
 
-	_, err := s.collection.Doc(docID).Update(ctx, updateParams)
+	err = s.withRetry(ctx, "UpdateDoc", isIdempotentUpdate(updateParams), func(ctx context.Context) error {
+		_, err := s.collection.Doc(docID).Update(ctx, updateParams)
+		return err
+	})
 	if status.Code(err) == codes.NotFound {
 		return ErrNotFound
 	}
 	return err
 }
 
+// UpdateResult reports the outcome of updating a single document during a bulk update.
+type UpdateResult struct {
+	DocID string
+	Err   error
+}
+
+// UpdateDocsByQuery applies updateParams to every document matching the query through the bulk writer.
+// Update values may use Firestore sentinels such as firestore.Increment, firestore.ArrayUnion,
+// firestore.ArrayRemove and firestore.Delete. Expired documents are not updated. Individual write
+// failures do not stop the remaining updates; they are reported per document in the returned results.
+func (s *GenericStore) UpdateDocsByQuery(ctx context.Context, query []QueryParameter, updateParams []firestore.Update) (_ []UpdateResult, err error) {
+	ctx, done := s.observe(ctx, "UpdateDocsByQuery", tracing.QueryKey.String(queryShape(query)))
+	defer func() { err = done(err) }()
+
+	if updateParams, err = s.geohashUpdates(updateParams); err != nil {
+		return nil, err
+	}
+
+	docs, err := s.ReadCollection(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, ErrNotFound
+	}
+
+	results := make([]UpdateResult, len(docs))
+	jobs := make([]*firestore.BulkWriterJob, len(docs))
+
+	bulkWriter := s.client.BulkWriter(ctx)
+	for i, doc := range docs {
+		results[i].DocID = doc.Ref.ID
+		job, err := bulkWriter.Update(doc.Ref, updateParams)
+		if err != nil {
+			results[i].Err = errs.From(err)
+			continue
+		}
+		jobs[i] = job
+	}
+	bulkWriter.End()
+
+	for i, job := range jobs {
+		if job == nil {
+			continue
+		}
+		if _, err := job.Results(); err != nil {
+			if status.Code(err) == codes.NotFound {
+				err = ErrNotFound
+			}
+			results[i].Err = errs.From(err)
+		}
+	}
+	return results, nil
+}
+
 // WatchCollection listens for realtime updates matching the provided query and invokes onSnapshot
 // with the current set of matching documents each time a snapshot is received. It returns a stop
-// function to end the watch.
+// function to end the watch. Expired documents are left out, but a document expiring does not by
+// itself trigger a new snapshot.
 func (s *GenericStore) WatchCollection(ctx context.Context, query []QueryParameter, onSnapshot func([]*firestore.DocumentSnapshot)) (func(), error) {
 	// Create a child context we can cancel independently
 	watchCtx, cancel := context.WithCancel(ctx)
@@ -236,7 +650,7 @@ func (s *GenericStore) WatchCollection(ctx context.Context, query []QueryParamet
 				}
 				docs = append(docs, doc)
 			}
-			onSnapshot(docs)
+			onSnapshot(s.filterExpired(watchCtx, docs))
 		}
 	}()
 
@@ -244,10 +658,17 @@ func (s *GenericStore) WatchCollection(ctx context.Context, query []QueryParamet
 	return stop, nil
 }
 
-func (s *GenericStore) GenerateNIDs(n int) ([]string, error) {
+// GenerateNIDs returns n new document IDs from the store's ID generator. The IDs are reserved against
+// each other, so a generator that repeats an ID fails with ErrAlreadyExists.
+func (s *GenericStore) GenerateNIDs(ctx context.Context, n int) ([]string, error) {
+	ctx = withReservedIDs(ctx, make(map[string]bool, n))
 	ids := make([]string, n)
 	for i := 0; i < n; i++ {
-		ids[i] = s.collection.NewDoc().ID
+		id, err := s.newID(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
 	}
 	return ids, nil
 }
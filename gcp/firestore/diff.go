@@ -0,0 +1,160 @@
+package firestore
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/maxcraig112/go-crud/errs"
+	"github.com/maxcraig112/go-crud/tracing"
+)
+
+// DiffUpdates compares two values of the same Firestore-tagged struct type and returns the minimal
+// updates that turn oldValue into newValue. Nested structs and maps are compared field by field,
+// keys missing from the new map are deleted, and slices that differ are replaced whole since
+// Firestore cannot update individual array elements. Zero fields tagged serverTimestamp are set to
+// firestore.ServerTimestamp, as Set would write them.
+func DiffUpdates(oldValue, newValue interface{}) ([]firestore.Update, error) {
+	ov, nv := reflect.Indirect(reflect.ValueOf(oldValue)), reflect.Indirect(reflect.ValueOf(newValue))
+	if !ov.IsValid() || !nv.IsValid() {
+		return nil, errs.New(errs.Invalid, "cannot diff nil values")
+	}
+	if ov.Type() != nv.Type() {
+		return nil, errs.Newf(errs.Invalid, "cannot diff %s against %s", ov.Type(), nv.Type())
+	}
+	if ov.Kind() != reflect.Struct {
+		return nil, errs.Newf(errs.Invalid, "diff values must be structs, got %s", ov.Type())
+	}
+
+	var updates []firestore.Update
+	diffStruct(nil, ov, nv, &updates)
+	return updates, nil
+}
+
+func diffStruct(prefix firestore.FieldPath, ov, nv reflect.Value, updates *[]firestore.Update) {
+	for _, f := range structFields(ov.Type()) {
+		path := appendPath(prefix, f.name)
+		of, nf := ov.FieldByIndex(f.index), nv.FieldByIndex(f.index)
+		if f.serverTimestamp && nf.IsZero() {
+			*updates = append(*updates, firestore.Update{FieldPath: path, Value: firestore.ServerTimestamp})
+			continue
+		}
+		if f.omitEmpty && nf.IsZero() {
+			if !of.IsZero() {
+				*updates = append(*updates, firestore.Update{FieldPath: path, Value: firestore.Delete})
+			}
+			continue
+		}
+		diffValue(path, of, nf, updates)
+	}
+}
+
+func diffValue(path firestore.FieldPath, ov, nv reflect.Value, updates *[]firestore.Update) {
+	set := func() {
+		*updates = append(*updates, firestore.Update{FieldPath: path, Value: nv.Interface()})
+	}
+
+	switch {
+	case ov.Type() == typeOfTime:
+		if !ov.Interface().(time.Time).Equal(nv.Interface().(time.Time)) {
+			set()
+		}
+	case isLeafType(ov.Type()):
+		if !reflect.DeepEqual(ov.Interface(), nv.Interface()) {
+			set()
+		}
+	case ov.Kind() == reflect.Ptr || ov.Kind() == reflect.Interface:
+		if ov.IsNil() || nv.IsNil() {
+			if ov.IsNil() != nv.IsNil() {
+				set()
+			}
+			return
+		}
+		oe, ne := ov.Elem(), nv.Elem()
+		if oe.Type() != ne.Type() {
+			set()
+			return
+		}
+		diffValue(path, oe, ne, updates)
+	case ov.Kind() == reflect.Struct:
+		diffStruct(path, ov, nv, updates)
+	case ov.Kind() == reflect.Map && ov.Type().Key().Kind() == reflect.String:
+		if ov.IsNil() != nv.IsNil() {
+			set()
+			return
+		}
+		for _, key := range sortedMapKeys(ov) {
+			if !nv.MapIndex(key).IsValid() {
+				*updates = append(*updates, firestore.Update{FieldPath: appendPath(path, key.String()), Value: firestore.Delete})
+			}
+		}
+		for _, key := range sortedMapKeys(nv) {
+			keyPath := appendPath(path, key.String())
+			oldEntry := ov.MapIndex(key)
+			if !oldEntry.IsValid() {
+				*updates = append(*updates, firestore.Update{FieldPath: keyPath, Value: nv.MapIndex(key).Interface()})
+				continue
+			}
+			diffValue(keyPath, oldEntry, nv.MapIndex(key), updates)
+		}
+	default:
+		if !reflect.DeepEqual(ov.Interface(), nv.Interface()) {
+			set()
+		}
+	}
+}
+
+// sortedMapKeys returns the keys of a string-keyed map in order, keeping diffs deterministic.
+func sortedMapKeys(m reflect.Value) []reflect.Value {
+	keys := m.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	return keys
+}
+
+// appendPath returns a new field path so sibling paths never share a backing array.
+func appendPath(prefix firestore.FieldPath, name string) firestore.FieldPath {
+	path := make(firestore.FieldPath, len(prefix), len(prefix)+1)
+	copy(path, prefix)
+	return append(path, name)
+}
+
+// PatchOptions controls how PatchDoc applies a diff.
+type PatchOptions struct {
+	// LastUpdateTime, if set, makes the patch fail with a Conflict error when the stored document
+	// has been modified since that time.
+	LastUpdateTime time.Time
+}
+
+// PatchDoc updates the document with the given ID with the minimal set of changes between oldValue and
+// newValue. It is a no-op when the values are equal.
+func (s *GenericStore) PatchDoc(ctx context.Context, docID string, oldValue, newValue interface{}, opts PatchOptions) (err error) {
+	ctx, done := s.observe(ctx, "PatchDoc", tracing.DocIDKey.String(docID))
+	defer func() { err = done(err) }()
+
+	updates, err := DiffUpdates(oldValue, newValue)
+	if err != nil || len(updates) == 0 {
+		return err
+	}
+	if updates, err = s.geohashUpdates(updates); err != nil {
+		return err
+	}
+
+	var preconds []firestore.Precondition
+	if !opts.LastUpdateTime.IsZero() {
+		preconds = append(preconds, firestore.LastUpdateTime(opts.LastUpdateTime))
+	}
+
+	err = s.withRetry(ctx, "PatchDoc", isIdempotentUpdate(updates), func(ctx context.Context) error {
+		_, err := s.collection.Doc(docID).Update(ctx, updates, preconds...)
+		return err
+	})
+	if status.Code(err) == codes.NotFound {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,149 @@
+package firestore
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/genproto/googleapis/type/latlng"
+)
+
+type diffAddress struct {
+	City string `firestore:"city"`
+	Zip  string `firestore:"zip"`
+}
+
+type diffDoc struct {
+	Name      string                 `firestore:"name"`
+	Nickname  string                 `firestore:"nickname,omitempty"`
+	Tags      []string               `firestore:"tags"`
+	Address   diffAddress            `firestore:"address"`
+	Manager   *diffAddress           `firestore:"manager"`
+	Attrs     map[string]interface{} `firestore:"attrs"`
+	Location  *latlng.LatLng         `firestore:"location"`
+	CreatedAt time.Time              `firestore:"createdAt"`
+	UpdatedAt time.Time              `firestore:"updatedAt,serverTimestamp"`
+	Ignored   string                 `firestore:"-"`
+}
+
+func TestDiffUpdates(t *testing.T) {
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	updated := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+	base := func() diffDoc {
+		return diffDoc{
+			Name:      "alice",
+			Nickname:  "al",
+			Tags:      []string{"a", "b"},
+			Address:   diffAddress{City: "Paris", Zip: "75001"},
+			Attrs:     map[string]interface{}{"x": int64(1), "y": "two"},
+			Location:  &latlng.LatLng{Latitude: 1, Longitude: 2},
+			CreatedAt: created,
+			UpdatedAt: updated,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(d *diffDoc)
+		want   []firestore.Update
+	}{
+		{
+			name:   "equal values",
+			modify: func(d *diffDoc) {},
+		},
+		{
+			name:   "changed leaf",
+			modify: func(d *diffDoc) { d.Name = "bob" },
+			want:   []firestore.Update{{FieldPath: firestore.FieldPath{"name"}, Value: "bob"}},
+		},
+		{
+			name:   "same instant in another location",
+			modify: func(d *diffDoc) { d.CreatedAt = created.In(time.FixedZone("X", 3600)) },
+		},
+		{
+			name:   "changed time",
+			modify: func(d *diffDoc) { d.CreatedAt = created.Add(time.Second) },
+			want:   []firestore.Update{{FieldPath: firestore.FieldPath{"createdAt"}, Value: created.Add(time.Second)}},
+		},
+		{
+			name:   "nested struct field",
+			modify: func(d *diffDoc) { d.Address.City = "Lyon" },
+			want:   []firestore.Update{{FieldPath: firestore.FieldPath{"address", "city"}, Value: "Lyon"}},
+		},
+		{
+			name:   "slice replaced whole",
+			modify: func(d *diffDoc) { d.Tags = []string{"a", "c"} },
+			want:   []firestore.Update{{FieldPath: firestore.FieldPath{"tags"}, Value: []string{"a", "c"}}},
+		},
+		{
+			name:   "omitempty field cleared",
+			modify: func(d *diffDoc) { d.Nickname = "" },
+			want:   []firestore.Update{{FieldPath: firestore.FieldPath{"nickname"}, Value: firestore.Delete}},
+		},
+		{
+			name:   "map key removed and added",
+			modify: func(d *diffDoc) { d.Attrs = map[string]interface{}{"y": "two", "z": true} },
+			want: []firestore.Update{
+				{FieldPath: firestore.FieldPath{"attrs", "x"}, Value: firestore.Delete},
+				{FieldPath: firestore.FieldPath{"attrs", "z"}, Value: true},
+			},
+		},
+		{
+			name:   "map value changed",
+			modify: func(d *diffDoc) { d.Attrs = map[string]interface{}{"x": int64(2), "y": "two"} },
+			want:   []firestore.Update{{FieldPath: firestore.FieldPath{"attrs", "x"}, Value: int64(2)}},
+		},
+		{
+			name:   "pointer set",
+			modify: func(d *diffDoc) { d.Manager = &diffAddress{City: "Rome"} },
+			want:   []firestore.Update{{FieldPath: firestore.FieldPath{"manager"}, Value: &diffAddress{City: "Rome"}}},
+		},
+		{
+			name:   "geopoint compared as a value",
+			modify: func(d *diffDoc) { d.Location = &latlng.LatLng{Latitude: 1, Longitude: 3} },
+			want:   []firestore.Update{{FieldPath: firestore.FieldPath{"location"}, Value: &latlng.LatLng{Latitude: 1, Longitude: 3}}},
+		},
+		{
+			name:   "zero server timestamp",
+			modify: func(d *diffDoc) { d.UpdatedAt = time.Time{} },
+			want:   []firestore.Update{{FieldPath: firestore.FieldPath{"updatedAt"}, Value: firestore.ServerTimestamp}},
+		},
+		{
+			name:   "ignored field",
+			modify: func(d *diffDoc) { d.Ignored = "changed" },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldValue, newValue := base(), base()
+			tt.modify(&newValue)
+			got, err := DiffUpdates(oldValue, &newValue)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffUpdates() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffUpdatesInvalid(t *testing.T) {
+	tests := []struct {
+		name               string
+		oldValue, newValue interface{}
+	}{
+		{"nil", nil, diffDoc{}},
+		{"different types", diffDoc{}, diffAddress{}},
+		{"not structs", map[string]interface{}{}, map[string]interface{}{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DiffUpdates(tt.oldValue, tt.newValue); err == nil {
+				t.Error("DiffUpdates() succeeded, want an error")
+			}
+		})
+	}
+}
@@ -1,12 +1,11 @@
 package firestore
 
 import (
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"github.com/maxcraig112/go-crud/errs"
 )
 
 // ErrNotFound is returned when a document is not found.
-var ErrNotFound = status.Error(codes.NotFound, "document not found")
+var ErrNotFound error = errs.New(errs.NotFound, "document not found")
 
 // ErrAlreadyExists is returned when a document already exists.
-var ErrAlreadyExists = status.Error(codes.AlreadyExists, "document already exists")
+var ErrAlreadyExists error = errs.New(errs.Conflict, "document already exists")
@@ -0,0 +1,168 @@
+package firestore
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/rs/zerolog/log"
+
+	"github.com/maxcraig112/go-crud/tracing"
+)
+
+// QueryProfile is Firestore's query plan and, when the query was analyzed, its execution stats.
+type QueryProfile struct {
+	Operation string
+	Query     string
+	// IndexesUsed lists the indexes selected by the planner, e.g.
+	// {"query_scope": "Collection", "properties": "(status ASC, __name__ ASC)"}.
+	IndexesUsed []map[string]any
+
+	// The fields below are only set when the query was analyzed.
+	Analyzed            bool
+	ResultsReturned     int64
+	ReadOperations      int64
+	DocumentsScanned    int64
+	IndexEntriesScanned int64
+	ExecutionDuration   time.Duration
+	DebugStats          map[string]any
+}
+
+// QueryProfiler receives the profile of every query run by a store.
+type QueryProfiler func(ctx context.Context, profile *QueryProfile)
+
+// WithQueryProfiler analyzes every ReadCollection and aggregation query the store runs and passes
+// the profile to profiler. Analyzed queries are billed as normal, but carry extra response overhead,
+// so this is intended for debugging rather than production traffic.
+func (s *GenericStore) WithQueryProfiler(profiler QueryProfiler) *GenericStore {
+	s.queryProfiler = profiler
+	return s
+}
+
+// WithSlowQueryLog logs a warning with the query shape for every ReadCollection and aggregation
+// query that takes longer than threshold.
+func (s *GenericStore) WithSlowQueryLog(threshold time.Duration) *GenericStore {
+	s.slowQueryThreshold = threshold
+	return s
+}
+
+// ExplainQuery returns Firestore's plan for a query. If analyze is true the query is also executed
+// and the profile includes its execution stats; the documents themselves are discarded.
+func (s *GenericStore) ExplainQuery(ctx context.Context, query []QueryParameter, analyze bool) (_ *QueryProfile, err error) {
+	ctx, done := s.observe(ctx, "ExplainQuery", tracing.QueryKey.String(queryShape(query)))
+	defer func() { err = done(err) }()
+
+	var metrics *firestore.ExplainMetrics
+	err = s.withRetry(ctx, "ExplainQuery", true, func(ctx context.Context) error {
+		_, metrics, err = s.getAll(ctx, s.buildQuery(ctx, query), &firestore.ExplainOptions{Analyze: analyze})
+		return err
+	})
+	if err != nil {
+		return nil, s.indexError(query, err)
+	}
+	return newQueryProfile("ExplainQuery", query, metrics), nil
+}
+
+// buildQuery applies query filters to the store's collection, pinned to the context's read time if
+// it has one.
+func (s *GenericStore) buildQuery(ctx context.Context, query []QueryParameter) firestore.Query {
+	result := s.queryCollection(ctx).Query
+	for _, q := range query {
+		result = result.Where(q.Path, q.Op, q.Value)
+	}
+	return result
+}
+
+// explainOptions returns the explain options queries should run with, or nil if the store has no
+// profiler.
+func (s *GenericStore) explainOptions() *firestore.ExplainOptions {
+	if s.queryProfiler == nil {
+		return nil
+	}
+	return &firestore.ExplainOptions{Analyze: true}
+}
+
+// getAll runs q to completion, returning its explain metrics if explain is set.
+func (s *GenericStore) getAll(ctx context.Context, q firestore.Query, explain *firestore.ExplainOptions) ([]*firestore.DocumentSnapshot, *firestore.ExplainMetrics, error) {
+	if explain != nil {
+		q = q.WithRunOptions(*explain)
+	}
+	iter := q.Documents(ctx)
+	defer iter.Stop()
+
+	docs, err := iter.GetAll()
+	if err != nil || explain == nil {
+		return docs, nil, err
+	}
+	metrics, err := iter.ExplainMetrics()
+	if err != nil {
+		return nil, nil, err
+	}
+	return docs, metrics, nil
+}
+
+// profileQuery reports a completed query to the store's profiler and slow query log.
+func (s *GenericStore) profileQuery(ctx context.Context, operation string, query []QueryParameter, start time.Time, results int, metrics *firestore.ExplainMetrics) {
+	if s.queryProfiler != nil && metrics != nil {
+		s.queryProfiler(ctx, newQueryProfile(operation, query, metrics))
+	}
+
+	elapsed := time.Since(start)
+	if s.slowQueryThreshold <= 0 || elapsed < s.slowQueryThreshold {
+		return
+	}
+	log.Warn().
+		Str("collection", s.collection.Path).
+		Str("operation", operation).
+		Str("query", queryShape(query)).
+		Dur("duration", elapsed).
+		Int("results", results).
+		Msg("Slow Firestore query")
+}
+
+func newQueryProfile(operation string, query []QueryParameter, metrics *firestore.ExplainMetrics) *QueryProfile {
+	profile := &QueryProfile{Operation: operation, Query: queryShape(query)}
+	if metrics == nil {
+		return profile
+	}
+
+	if metrics.PlanSummary != nil {
+		for _, index := range metrics.PlanSummary.IndexesUsed {
+			if index != nil {
+				profile.IndexesUsed = append(profile.IndexesUsed, *index)
+			}
+		}
+	}
+
+	stats := metrics.ExecutionStats
+	if stats == nil {
+		return profile
+	}
+	profile.Analyzed = true
+	profile.ResultsReturned = stats.ResultsReturned
+	profile.ReadOperations = stats.ReadOperations
+	if stats.ExecutionDuration != nil {
+		profile.ExecutionDuration = *stats.ExecutionDuration
+	}
+	if stats.DebugStats != nil {
+		profile.DebugStats = *stats.DebugStats
+		profile.DocumentsScanned = debugStat(profile.DebugStats, "documents_scanned")
+		profile.IndexEntriesScanned = debugStat(profile.DebugStats, "index_entries_scanned")
+	}
+	return profile
+}
+
+// debugStat reads a counter from Firestore's debug stats, which reports numbers as strings.
+func debugStat(stats map[string]any, key string) int64 {
+	switch v := stats[key].(type) {
+	case string:
+		n, _ := strconv.ParseInt(v, 10, 64)
+		return n
+	case float64:
+		return int64(v)
+	case int64:
+		return v
+	}
+	return 0
+}
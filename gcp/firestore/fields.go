@@ -0,0 +1,110 @@
+package firestore
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/genproto/googleapis/type/latlng"
+)
+
+var (
+	typeOfTime        = reflect.TypeOf(time.Time{})
+	typeOfLatLng      = reflect.TypeOf((*latlng.LatLng)(nil))
+	typeOfDocumentRef = reflect.TypeOf((*firestore.DocumentRef)(nil))
+)
+
+// structField describes a field of a Firestore-tagged struct.
+type structField struct {
+	name      string
+	index     []int
+	typ       reflect.Type
+	omitEmpty bool
+	// serverTimestamp fields are set to the commit time when written with their zero value.
+	serverTimestamp bool
+}
+
+// structFields returns the fields Firestore stores for struct type t, honouring firestore tags and
+// promoting the fields of untagged embedded structs the same way the Firestore client does.
+func structFields(t reflect.Type) []structField {
+	var out []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() && !f.Anonymous {
+			continue
+		}
+
+		tag := f.Tag.Get("firestore")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for _, promoted := range structFields(ft) {
+					promoted.index = append([]int{i}, promoted.index...)
+					out = append(out, promoted)
+				}
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		out = append(out, structField{
+			name:            name,
+			index:           []int{i},
+			typ:             ft,
+			omitEmpty:       strings.Contains(opts, "omitempty"),
+			serverTimestamp: strings.Contains(opts, "serverTimestamp"),
+		})
+	}
+	return out
+}
+
+// isLeafType reports whether t is stored as a single Firestore value rather than a nested map.
+func isLeafType(t reflect.Type) bool {
+	return t == typeOfTime || t == typeOfLatLng || t == typeOfDocumentRef
+}
+
+// lookupFieldType resolves a field path against type t, returning the type of the field it names.
+// Map values and interface fields accept any key beneath them.
+func lookupFieldType(t reflect.Type, path []string) (reflect.Type, bool) {
+	for _, part := range path {
+		for t.Kind() == reflect.Ptr && !isLeafType(t) {
+			t = t.Elem()
+		}
+
+		switch {
+		case t.Kind() == reflect.Interface:
+			return t, true
+		case t.Kind() == reflect.Map && t.Key().Kind() == reflect.String:
+			t = t.Elem()
+		case t.Kind() == reflect.Struct && !isLeafType(t):
+			found := false
+			for _, f := range structFields(t) {
+				if f.name == part {
+					t, found = f.typ, true
+					break
+				}
+			}
+			if !found {
+				return nil, false
+			}
+		default:
+			// leaf values and slices cannot be indexed by field path
+			return nil, false
+		}
+	}
+	return t, true
+}
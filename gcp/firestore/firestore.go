@@ -5,33 +5,76 @@ import (
 
 	"cloud.google.com/go/firestore"
 	"github.com/joho/godotenv"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 const (
-	PROJECTID_ENV string = "FIRESTORE_PROJECTID"
-	DATABSEID_ENV string = "FIRESTORE_DATABASEID"
+	PROJECTID_ENV    string = "FIRESTORE_PROJECTID"
+	DATABSEID_ENV    string = "FIRESTORE_DATABASEID"
+	EMULATORHOST_ENV string = "FIRESTORE_EMULATOR_HOST"
 )
 
+// EmulatorProjectID is used when connecting to the emulator without a configured project, and is the
+// project firestoretest creates test databases under.
+const EmulatorProjectID = "demo-go-crud"
+
 type FireStoreClientConfig struct {
-	ProjectID  string
-	DatabaseID string
+	ProjectID  string `json:"projectId"`
+	DatabaseID string `json:"databaseId"`
+	// EmulatorHost is the host:port of a Firestore emulator. When set, the client connects to the
+	// emulator without credentials instead of the real Firestore service.
+	EmulatorHost string `json:"emulatorHost,omitempty"`
 }
 
 // NewFirestoreClient initializes and returns a FirestoreClient using a specific database ID.
 func NewFirestoreClient(ctx context.Context, cfg FireStoreClientConfig) (*FirestoreClient, error) {
 	_ = godotenv.Load()
 
-	client, err := firestore.NewClientWithDatabase(ctx, cfg.ProjectID, cfg.DatabaseID)
+	var opts []option.ClientOption
+	var conn *grpc.ClientConn
+	if cfg.EmulatorHost != "" {
+		var err error
+		conn, err = grpc.NewClient(cfg.EmulatorHost,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithPerRPCCredentials(emulatorCreds{}),
+		)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, option.WithGRPCConn(conn))
+		if cfg.ProjectID == "" {
+			cfg.ProjectID = EmulatorProjectID
+		}
+	}
+
+	client, err := firestore.NewClientWithDatabase(ctx, cfg.ProjectID, cfg.DatabaseID, opts...)
 	if err != nil {
+		if conn != nil {
+			_ = conn.Close()
+		}
 		return nil, err
 	}
 
 	return &FirestoreClient{
 		client: client,
 		dbID:   cfg.DatabaseID,
+		conn:   conn,
 	}, nil
 }
 
+// emulatorCreds authenticates every request as an admin, which the Firestore emulator accepts.
+type emulatorCreds struct{}
+
+func (emulatorCreds) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer owner"}, nil
+}
+
+func (emulatorCreds) RequireTransportSecurity() bool {
+	return false
+}
+
 func (fc *FirestoreClient) BulkWriter(ctx context.Context) *firestore.BulkWriter {
 	return fc.client.BulkWriter(ctx)
 }
@@ -41,7 +84,23 @@ func (fc *FirestoreClient) GetCollection(path string) *firestore.CollectionRef {
 	return fc.client.Collection(path)
 }
 
+// GetAll retrieves multiple documents in a single batched request, in the order of docRefs.
+func (fc *FirestoreClient) GetAll(ctx context.Context, docRefs []*firestore.DocumentRef) ([]*firestore.DocumentSnapshot, error) {
+	return fc.client.GetAll(ctx, docRefs)
+}
+
+// RunTransaction runs f in a transaction, retrying it if the transaction is contended.
+func (fc *FirestoreClient) RunTransaction(ctx context.Context, f func(context.Context, *firestore.Transaction) error, opts ...firestore.TransactionOption) error {
+	return fc.client.RunTransaction(ctx, f, opts...)
+}
+
 // Close closes the Firestore client connection.
 func (fc *FirestoreClient) Close() error {
-	return fc.client.Close()
+	err := fc.client.Close()
+	if fc.conn != nil {
+		if connErr := fc.conn.Close(); err == nil {
+			err = connErr
+		}
+	}
+	return err
 }
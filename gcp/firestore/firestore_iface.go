@@ -4,12 +4,15 @@ import (
 	"context"
 
 	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc"
 )
 
 // FirestoreClientInterface defines methods for Firestore operations.
 type FirestoreClientInterface interface {
 	BulkWriter(ctx context.Context) *firestore.BulkWriter
 	GetCollection(path string) *firestore.CollectionRef
+	GetAll(ctx context.Context, docRefs []*firestore.DocumentRef) ([]*firestore.DocumentSnapshot, error)
+	RunTransaction(ctx context.Context, f func(context.Context, *firestore.Transaction) error, opts ...firestore.TransactionOption) error
 	Close() error
 }
 
@@ -17,4 +20,6 @@ type FirestoreClientInterface interface {
 type FirestoreClient struct {
 	client *firestore.Client
 	dbID   string
+	// conn is the emulator connection, which the Firestore client does not close itself
+	conn *grpc.ClientConn
 }
@@ -0,0 +1,181 @@
+// Package firestoretest provides helpers for running integration tests against a local Firestore emulator.
+package firestoretest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maxcraig112/go-crud/gcp/firestore"
+)
+
+// startTimeout is how long StartEmulator waits for the emulator to accept requests.
+const startTimeout = 30 * time.Second
+
+// Emulator is a running Firestore emulator.
+type Emulator struct {
+	Host string
+	cmd  *exec.Cmd
+}
+
+// Locate returns the emulator at FIRESTORE_EMULATOR_HOST, skipping the test if it is not set.
+func Locate(t testing.TB) *Emulator {
+	t.Helper()
+	host := os.Getenv(firestore.EMULATORHOST_ENV)
+	if host == "" {
+		t.Skipf("%s not set, skipping Firestore emulator test", firestore.EMULATORHOST_ENV)
+	}
+	return &Emulator{Host: host}
+}
+
+// StartEmulator starts a Firestore emulator on a free local port using the gcloud CLI and waits for
+// it to accept requests. If FIRESTORE_EMULATOR_HOST is already set, that emulator is used instead.
+// It is intended to be called from TestMain, with Stop deferred.
+func StartEmulator(ctx context.Context) (*Emulator, error) {
+	if host := os.Getenv(firestore.EMULATORHOST_ENV); host != "" {
+		return &Emulator{Host: host}, nil
+	}
+
+	gcloud, err := exec.LookPath("gcloud")
+	if err != nil {
+		return nil, fmt.Errorf("gcloud not found, cannot start Firestore emulator: %w", err)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find free port: %w", err)
+	}
+	host := fmt.Sprintf("127.0.0.1:%d", port)
+
+	cmd := exec.Command(gcloud, "emulators", "firestore", "start", "--host-port="+host)
+	startProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start Firestore emulator: %w", err)
+	}
+
+	e := &Emulator{Host: host, cmd: cmd}
+	if err := e.waitReady(ctx); err != nil {
+		_ = e.Stop()
+		return nil, err
+	}
+	return e, nil
+}
+
+// Stop terminates the emulator if it was started by StartEmulator, including the Java process the
+// gcloud wrapper launches.
+func (e *Emulator) Stop() error {
+	if e.cmd == nil || e.cmd.Process == nil {
+		return nil
+	}
+	if err := killProcessGroup(e.cmd); err != nil {
+		return err
+	}
+	_ = e.cmd.Wait()
+	return nil
+}
+
+// NewClient returns a client connected to a database created for this test alone. The database is
+// wiped and the client closed when the test finishes.
+func (e *Emulator) NewClient(t testing.TB) *firestore.FirestoreClient {
+	t.Helper()
+	ctx := context.Background()
+
+	databaseID := testDatabaseID(t.Name())
+	client, err := firestore.NewFirestoreClient(ctx, firestore.FireStoreClientConfig{
+		ProjectID:    firestore.EmulatorProjectID,
+		DatabaseID:   databaseID,
+		EmulatorHost: e.Host,
+	})
+	if err != nil {
+		t.Fatalf("failed to create Firestore emulator client: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := e.Wipe(ctx, databaseID); err != nil {
+			t.Errorf("failed to wipe Firestore emulator database %s: %v", databaseID, err)
+		}
+		if err := client.Close(); err != nil {
+			t.Errorf("failed to close Firestore emulator client: %v", err)
+		}
+	})
+	return client
+}
+
+// Wipe deletes every document in the given emulator database.
+func (e *Emulator) Wipe(ctx context.Context, databaseID string) error {
+	url := fmt.Sprintf("http://%s/emulator/v1/projects/%s/databases/%s/documents", e.Host, firestore.EmulatorProjectID, databaseID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status wiping emulator database: %s", resp.Status)
+	}
+	return nil
+}
+
+func (e *Emulator) waitReady(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, startTimeout)
+	defer cancel()
+
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+e.Host+"/", nil)
+		if err != nil {
+			return err
+		}
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Firestore emulator at %s did not become ready: %w", e.Host, ctx.Err())
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// testDatabaseID builds a valid, unique database ID from a test name.
+func testDatabaseID(testName string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(testName) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('-')
+		}
+	}
+	name := b.String()
+	if len(name) > 40 {
+		name = name[:40]
+	}
+
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return fmt.Sprintf("t-%s-%s", strings.Trim(name, "-"), hex.EncodeToString(suffix))
+}
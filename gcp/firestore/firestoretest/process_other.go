@@ -0,0 +1,13 @@
+//go:build !unix
+
+package firestoretest
+
+import "os/exec"
+
+func startProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills cmd. Process groups are not available on this platform, so processes it
+// started may need to be stopped separately.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package firestoretest
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// startProcessGroup runs cmd in its own process group, so the Java emulator gcloud launches can be
+// stopped along with it.
+func startProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills cmd and every process in its group.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,559 @@
+package firestore
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/genproto/googleapis/type/latlng"
+
+	"github.com/maxcraig112/go-crud/errs"
+	"github.com/maxcraig112/go-crud/tracing"
+)
+
+// geohashAlphabet is the base32 alphabet used by geohashes.
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+const (
+	// maxGeohashPrecision is the geohash length stored for every geopoint, about 1.2m x 0.6m.
+	maxGeohashPrecision = 10
+	// maxGeoRanges bounds the number of geohash range queries a geo query fans out to.
+	maxGeoRanges = 9
+	// earthRadiusMeters is the mean radius of the Earth.
+	earthRadiusMeters = 6371008.8
+)
+
+// WithGeoIndex maintains a geohash of the geopoint stored at field in hashField whenever a document
+// is written through CreateDoc, CreateDocsBatch, SetDocsBatch, UpdateDoc, UpdateDocsByQuery or the
+// patch methods, allowing the field to be queried with WithinRadius and WithinBounds. Both may be
+// dotted paths into nested maps, e.g. "address.location". If hashField is empty it defaults to
+// field + "Geohash". Documents written before the index was added can be updated with
+// BackfillGeohashes.
+func (s *GenericStore) WithGeoIndex(field, hashField string) *GenericStore {
+	if hashField == "" {
+		hashField = field + "Geohash"
+	}
+	if s.geoIndexes == nil {
+		s.geoIndexes = make(map[string]string)
+	}
+	s.geoIndexes[field] = hashField
+	return s
+}
+
+// Geohash encodes a location as a geohash of the given length, between 1 and 12 characters.
+func Geohash(lat, lng float64, precision int) string {
+	precision = max(1, min(precision, 12))
+	latRange, lngRange := [2]float64{-90, 90}, [2]float64{-180, 180}
+
+	var hash strings.Builder
+	bit, ch, even := 0, 0, true
+	for hash.Len() < precision {
+		r, v := &latRange, lat
+		if even {
+			r, v = &lngRange, lng
+		}
+		mid := (r[0] + r[1]) / 2
+		ch <<= 1
+		if v >= mid {
+			ch |= 1
+			r[0] = mid
+		} else {
+			r[1] = mid
+		}
+		even = !even
+		if bit++; bit == 5 {
+			hash.WriteByte(geohashAlphabet[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return hash.String()
+}
+
+// DistanceMeters returns the great-circle distance between two points.
+func DistanceMeters(a, b *latlng.LatLng) float64 {
+	lat1, lat2 := toRadians(a.Latitude), toRadians(b.Latitude)
+	dLat, dLng := lat2-lat1, toRadians(b.Longitude-a.Longitude)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+func toRadians(deg float64) float64 { return deg * math.Pi / 180 }
+
+func toDegrees(rad float64) float64 { return rad * 180 / math.Pi }
+
+// withGeohashes returns data with the store's geohash fields set from its geopoints. Structs holding
+// an indexed field or its geohash are converted to maps so the hash can be added alongside their
+// fields.
+func (s *GenericStore) withGeohashes(data interface{}) (interface{}, error) {
+	if len(s.geoIndexes) == 0 {
+		return data, nil
+	}
+	fields, err := toFieldMap(data)
+	if err != nil {
+		return nil, err
+	}
+	for field, hashField := range s.geoIndexes {
+		if _, err := setGeohash(fields, splitPath(field), splitPath(hashField)); err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
+
+// geohashUpdates returns updates with the geohashes of the indexed fields they change kept in step.
+// An update of a map holding an indexed field, such as "location" for "location.point", updates the
+// field too. When the geohash is stored in the same map it is added to the update's value, as
+// Firestore rejects updates of a field and its parent together; otherwise a separate update is added.
+func (s *GenericStore) geohashUpdates(updates []firestore.Update) ([]firestore.Update, error) {
+	if len(s.geoIndexes) == 0 {
+		return updates, nil
+	}
+	out := append([]firestore.Update(nil), updates...)
+	for i, u := range updates {
+		path := []string(u.FieldPath)
+		if u.Path != "" {
+			path = splitPath(u.Path)
+		}
+		for field, hashField := range s.geoIndexes {
+			fieldPath, hashPath := splitPath(field), splitPath(hashField)
+			if !hasPathPrefix(fieldPath, path) {
+				continue
+			}
+			if len(path) == len(fieldPath) {
+				hash, err := geohashOf(field, out[i].Value)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, geohashUpdate(hashPath, hash))
+				continue
+			}
+
+			// the update replaces a map holding the indexed field
+			var fragment map[string]interface{}
+			if isNestedDoc(out[i].Value) {
+				var err error
+				if fragment, err = toFieldMap(out[i].Value); err != nil {
+					return nil, err
+				}
+			}
+			if hasPathPrefix(hashPath, path) {
+				if fragment != nil {
+					if _, err := setGeohash(fragment, fieldPath[len(path):], hashPath[len(path):]); err != nil {
+						return nil, err
+					}
+					out[i].Value = fragment
+				}
+				continue
+			}
+			hash := ""
+			if fragment != nil {
+				var err error
+				if hash, err = geohashAt(fragment, fieldPath[len(path):], field); err != nil {
+					return nil, err
+				}
+			}
+			out = append(out, geohashUpdate(hashPath, hash))
+		}
+	}
+	return out, nil
+}
+
+// geohashUpdate returns the update writing hash to the field at path, deleting it if hash is empty.
+func geohashUpdate(path []string, hash string) firestore.Update {
+	var value interface{} = hash
+	if hash == "" {
+		value = firestore.Delete
+	}
+	return firestore.Update{FieldPath: firestore.FieldPath(path), Value: value}
+}
+
+// setGeohash sets the field at hashPath in fields to the geohash of the geopoint at fieldPath,
+// converting the structs along both paths to maps. It reports whether a geohash was set.
+func setGeohash(fields map[string]interface{}, fieldPath, hashPath []string) (bool, error) {
+	hash, err := geohashAt(fields, fieldPath, strings.Join(fieldPath, "."))
+	if err != nil || hash == "" {
+		return false, err
+	}
+	parent, ok, err := nestedMap(fields, hashPath[:len(hashPath)-1], true)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, errs.Newf(errs.Invalid, "geohash field %s is inside a value that is not a map", strings.Join(hashPath, "."))
+	}
+	parent[hashPath[len(hashPath)-1]] = hash
+	return true, nil
+}
+
+// geohashAt returns the geohash of the geopoint at path in fields, or "" if there is none. field names
+// the indexed field in errors.
+func geohashAt(fields map[string]interface{}, path []string, field string) (string, error) {
+	parent, ok, err := nestedMap(fields, path[:len(path)-1], false)
+	if err != nil || !ok {
+		return "", err
+	}
+	value, ok := parent[path[len(path)-1]]
+	if !ok {
+		return "", nil
+	}
+	return geohashOf(field, value)
+}
+
+// nestedMap returns the map at path in fields. Structs along the path are converted to maps and maps
+// are copied, replacing them in their parents, so the result can be modified without changing the
+// caller's data. Missing maps are created if create is set; otherwise ok is false when the path does
+// not lead to a map.
+func nestedMap(fields map[string]interface{}, path []string, create bool) (_ map[string]interface{}, ok bool, _ error) {
+	for _, key := range path {
+		value, exists := fields[key]
+		var child map[string]interface{}
+		switch {
+		case (!exists || value == nil) && create:
+			child = make(map[string]interface{})
+		case exists && isNestedDoc(value):
+			var err error
+			if child, err = toFieldMap(value); err != nil {
+				return nil, false, err
+			}
+		default:
+			return nil, false, nil
+		}
+		fields[key] = child
+		fields = child
+	}
+	return fields, true, nil
+}
+
+// isNestedDoc reports whether value is stored as a nested map: a map or a struct that is not a
+// timestamp, geopoint or reference.
+func isNestedDoc(value interface{}) bool {
+	if _, ok := value.(map[string]interface{}); ok {
+		return true
+	}
+	v := reflect.Indirect(reflect.ValueOf(value))
+	return v.IsValid() && v.Kind() == reflect.Struct && !isLeafType(v.Type()) && !isLeafType(reflect.TypeOf(value))
+}
+
+// splitPath splits a dotted field path into its segments.
+func splitPath(path string) []string {
+	return strings.Split(path, ".")
+}
+
+// hasPathPrefix reports whether path starts with the segments of prefix.
+func hasPathPrefix(path, prefix []string) bool {
+	if len(prefix) == 0 || len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// geohashOf returns the geohash of a geopoint field value, or "" if the field is cleared.
+func geohashOf(field string, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case *latlng.LatLng:
+		if v == nil {
+			return "", nil
+		}
+		return Geohash(v.Latitude, v.Longitude, maxGeohashPrecision), nil
+	case nil:
+		return "", nil
+	}
+	if value == firestore.Delete {
+		return "", nil
+	}
+	return "", errs.Newf(errs.Invalid, "geo indexed field %s must be a *latlng.LatLng, got %T", field, value)
+}
+
+// toFieldMap converts document data into a map of its top-level fields, honouring firestore tags.
+// Nested values are left for the Firestore client to encode.
+func toFieldMap(data interface{}) (map[string]interface{}, error) {
+	if m, ok := data.(map[string]interface{}); ok {
+		out := make(map[string]interface{}, len(m)+1)
+		for k, v := range m {
+			out[k] = v
+		}
+		return out, nil
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(data))
+	if !v.IsValid() || v.Kind() != reflect.Struct {
+		return nil, errs.Newf(errs.Invalid, "document data must be a struct or map, got %T", data)
+	}
+	out := make(map[string]interface{})
+	for _, f := range structFields(v.Type()) {
+		fv, err := v.FieldByIndexErr(f.index)
+		if err != nil {
+			// field promoted through a nil embedded pointer
+			continue
+		}
+		switch {
+		case f.serverTimestamp && fv.IsZero():
+			out[f.name] = firestore.ServerTimestamp
+		case f.omitEmpty && fv.IsZero():
+		default:
+			out[f.name] = fv.Interface()
+		}
+	}
+	return out, nil
+}
+
+// GeoQueryOptions controls WithinRadius and WithinBounds.
+type GeoQueryOptions struct {
+	// Filter restricts the documents searched. Filters are combined with a range filter on the
+	// geohash field, so each combination needs a composite index.
+	Filter []QueryParameter
+	// Limit caps the number of results after sorting by distance. Zero means no limit.
+	Limit int
+}
+
+// GeoResult is a document matched by a geo query along with its distance from the query centre.
+type GeoResult struct {
+	Doc            *firestore.DocumentSnapshot
+	DistanceMeters float64
+}
+
+// WithinRadius returns the documents whose geopoint field lies within radiusMeters of center,
+// nearest first. The field must be indexed with WithGeoIndex.
+func (s *GenericStore) WithinRadius(ctx context.Context, field string, center *latlng.LatLng, radiusMeters float64, opts GeoQueryOptions) (_ []GeoResult, err error) {
+	ctx, done := s.observe(ctx, "WithinRadius", tracing.QueryKey.String(queryShape(opts.Filter)))
+	defer func() { err = done(err) }()
+
+	if center == nil || radiusMeters <= 0 {
+		return nil, errs.New(errs.Invalid, "radius query needs a center and a positive radius")
+	}
+
+	return s.geoQuery(ctx, field, radiusBox(center, radiusMeters), center, opts, func(p *latlng.LatLng, distance float64) bool {
+		return distance <= radiusMeters
+	})
+}
+
+// radiusBox returns the smallest latitude/longitude box containing the circle of radiusMeters
+// around center. The circle is widest in longitude away from the centre's latitude, at
+// asin(sin(d)/cos(lat)) for an angular radius d, and spans every longitude if it reaches a pole.
+func radiusBox(center *latlng.LatLng, radiusMeters float64) geoBox {
+	d := radiusMeters / earthRadiusMeters
+	lat := toRadians(center.Latitude)
+	latDelta := toDegrees(d)
+
+	lngDelta := 180.0
+	if math.Abs(center.Latitude)+latDelta < 90 {
+		if ratio := math.Sin(d) / math.Cos(lat); ratio < 1 {
+			lngDelta = toDegrees(math.Asin(ratio))
+		}
+	}
+	return geoBox{
+		minLat: math.Max(-90, center.Latitude-latDelta),
+		maxLat: math.Min(90, center.Latitude+latDelta),
+		minLng: center.Longitude - lngDelta,
+		maxLng: center.Longitude + lngDelta,
+	}
+}
+
+// WithinBounds returns the documents whose geopoint field lies within the box with the given
+// south-west and north-east corners, nearest the box centre first. Boxes crossing the antimeridian
+// are given with sw.Longitude greater than ne.Longitude. The field must be indexed with
+// WithGeoIndex.
+func (s *GenericStore) WithinBounds(ctx context.Context, field string, sw, ne *latlng.LatLng, opts GeoQueryOptions) (_ []GeoResult, err error) {
+	ctx, done := s.observe(ctx, "WithinBounds", tracing.QueryKey.String(queryShape(opts.Filter)))
+	defer func() { err = done(err) }()
+
+	if sw == nil || ne == nil || sw.Latitude > ne.Latitude {
+		return nil, errs.New(errs.Invalid, "bounds need a south-west corner below the north-east corner")
+	}
+	box := geoBox{minLat: sw.Latitude, maxLat: ne.Latitude, minLng: sw.Longitude, maxLng: ne.Longitude}
+	if box.maxLng < box.minLng {
+		box.maxLng += 360
+	}
+	center := &latlng.LatLng{
+		Latitude:  (box.minLat + box.maxLat) / 2,
+		Longitude: normalizeLng((box.minLng + box.maxLng) / 2),
+	}
+
+	return s.geoQuery(ctx, field, box, center, opts, func(p *latlng.LatLng, distance float64) bool {
+		return box.contains(p)
+	})
+}
+
+// geoQuery fans out one range query per geohash cell covering box, then keeps the documents
+// accepted by match, sorted by distance from center.
+func (s *GenericStore) geoQuery(ctx context.Context, field string, box geoBox, center *latlng.LatLng, opts GeoQueryOptions, match func(*latlng.LatLng, float64) bool) ([]GeoResult, error) {
+	hashField, ok := s.geoIndexes[field]
+	if !ok {
+		return nil, errs.Newf(errs.Invalid, "field %s has no geo index", field)
+	}
+
+	s.trackQuery(append(opts.Filter[:len(opts.Filter):len(opts.Filter)], QueryParameter{Path: hashField, Op: ">="}))
+
+	cells := box.geohashCells()
+	type rangeResult struct {
+		docs []*firestore.DocumentSnapshot
+		err  error
+	}
+	results := make(chan rangeResult, len(cells))
+	for _, cell := range cells {
+		go func(cell string) {
+			q := s.buildQuery(ctx, opts.Filter).Where(hashField, ">=", cell).Where(hashField, "<=", cell+"~")
+			var docs []*firestore.DocumentSnapshot
+			err := s.withRetry(ctx, "GeoQuery", true, func(ctx context.Context) error {
+				var err error
+				docs, _, err = s.getAll(ctx, q, nil)
+				return err
+			})
+			results <- rangeResult{docs: docs, err: err}
+		}(cell)
+	}
+
+	seen := make(map[string]bool)
+	var matched []GeoResult
+	var firstErr error
+	for range cells {
+		r := <-results
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			continue
+		}
+		for _, doc := range s.filterExpired(ctx, r.docs) {
+			if seen[doc.Ref.ID] {
+				continue
+			}
+			seen[doc.Ref.ID] = true
+
+			value, err := doc.DataAt(field)
+			if err != nil {
+				continue
+			}
+			point, ok := value.(*latlng.LatLng)
+			if !ok || point == nil {
+				continue
+			}
+			distance := DistanceMeters(center, point)
+			if match(point, distance) {
+				matched = append(matched, GeoResult{Doc: doc, DistanceMeters: distance})
+			}
+		}
+	}
+	if firstErr != nil {
+		return nil, s.indexError(opts.Filter, firstErr)
+	}
+
+	sort.Slice(matched, func(i, j int) bool { return matched[i].DistanceMeters < matched[j].DistanceMeters })
+	if opts.Limit > 0 && len(matched) > opts.Limit {
+		matched = matched[:opts.Limit]
+	}
+	return matched, nil
+}
+
+// geoBox is a latitude/longitude box. Longitudes may extend past 180 for boxes crossing the
+// antimeridian.
+type geoBox struct {
+	minLat, maxLat, minLng, maxLng float64
+}
+
+func (b geoBox) contains(p *latlng.LatLng) bool {
+	if p.Latitude < b.minLat || p.Latitude > b.maxLat {
+		return false
+	}
+	lng := p.Longitude
+	if lng < b.minLng {
+		lng += 360
+	}
+	return lng >= b.minLng && lng <= b.maxLng
+}
+
+// geohashCells returns the geohash prefixes of the cells covering the box, using the longest
+// prefixes that keep the number of cells within maxGeoRanges.
+func (b geoBox) geohashCells() []string {
+	for precision := maxGeohashPrecision; precision > 1; precision-- {
+		if cells := b.cellsAt(precision, maxGeoRanges); cells != nil {
+			return cells
+		}
+	}
+	return b.cellsAt(1, len(geohashAlphabet))
+}
+
+// cellsAt returns the geohash cells of the given length covering the box, or nil if there would be
+// more than limit of them.
+func (b geoBox) cellsAt(precision, limit int) []string {
+	bits := precision * 5
+	cellLat := 180 / math.Pow(2, float64(bits/2))
+	cellLng := 360 / math.Pow(2, float64(bits-bits/2))
+
+	latStart := math.Floor((b.minLat+90)/cellLat)*cellLat - 90
+	lngStart := math.Floor((b.minLng+180)/cellLng)*cellLng - 180
+	rows := int(math.Floor((b.maxLat-latStart)/cellLat)) + 1
+	cols := int(math.Floor((b.maxLng-lngStart)/cellLng)) + 1
+	if rows*cols > limit*4 {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var cells []string
+	for r := 0; r < rows; r++ {
+		lat := math.Min(89.999999, latStart+(float64(r)+0.5)*cellLat)
+		for c := 0; c < cols; c++ {
+			lng := normalizeLng(lngStart + (float64(c)+0.5)*cellLng)
+			cell := Geohash(lat, lng, precision)
+			if !seen[cell] {
+				seen[cell] = true
+				cells = append(cells, cell)
+			}
+		}
+	}
+	if len(cells) > limit {
+		return nil
+	}
+	sort.Strings(cells)
+	return cells
+}
+
+// normalizeLng wraps a longitude into [-180, 180).
+func normalizeLng(lng float64) float64 {
+	lng = math.Mod(lng+180, 360)
+	if lng < 0 {
+		lng += 360
+	}
+	return lng - 180
+}
+
+// BackfillGeohashes sets the geohash fields of every document in the collection from their
+// geopoints, for documents written before WithGeoIndex was configured. It returns the number of
+// documents updated.
+func (s *GenericStore) BackfillGeohashes(ctx context.Context) (int, error) {
+	if len(s.geoIndexes) == 0 {
+		return 0, errs.New(errs.Invalid, "store has no geo index configured")
+	}
+	updated := 0
+	err := s.Scan(ctx, ScanOptions{}, func(doc *firestore.DocumentSnapshot) error {
+		var updates []firestore.Update
+		for field, hashField := range s.geoIndexes {
+			value, err := doc.DataAt(field)
+			if err != nil {
+				continue
+			}
+			point, ok := value.(*latlng.LatLng)
+			if !ok || point == nil {
+				continue
+			}
+			hash := Geohash(point.Latitude, point.Longitude, maxGeohashPrecision)
+			if current, err := doc.DataAt(hashField); err != nil || current != hash {
+				updates = append(updates, firestore.Update{FieldPath: firestore.FieldPath(splitPath(hashField)), Value: hash})
+			}
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		updated++
+		return s.UpdateDoc(ctx, doc.Ref.ID, updates)
+	})
+	return updated, err
+}
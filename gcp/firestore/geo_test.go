@@ -0,0 +1,198 @@
+package firestore
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/genproto/googleapis/type/latlng"
+)
+
+// destination returns the point distanceMeters from start along the given bearing in degrees.
+func destination(start *latlng.LatLng, bearing, distanceMeters float64) *latlng.LatLng {
+	d := distanceMeters / earthRadiusMeters
+	lat1, lng1, theta := toRadians(start.Latitude), toRadians(start.Longitude), toRadians(bearing)
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(d) + math.Cos(lat1)*math.Sin(d)*math.Cos(theta))
+	lng2 := lng1 + math.Atan2(math.Sin(theta)*math.Sin(d)*math.Cos(lat1), math.Cos(d)-math.Sin(lat1)*math.Sin(lat2))
+	return &latlng.LatLng{Latitude: toDegrees(lat2), Longitude: normalizeLng(toDegrees(lng2))}
+}
+
+func TestRadiusBoxCoversBoundary(t *testing.T) {
+	tests := []struct {
+		name         string
+		center       *latlng.LatLng
+		radiusMeters float64
+	}{
+		{"reported", &latlng.LatLng{Latitude: 42.346426, Longitude: 166.692336}, 3014.2},
+		{"equator", &latlng.LatLng{Latitude: 0, Longitude: 0}, 5000},
+		{"southern", &latlng.LatLng{Latitude: -33.8688, Longitude: 151.2093}, 1200},
+		{"high latitude", &latlng.LatLng{Latitude: 78.2232, Longitude: 15.6267}, 25000},
+		{"antimeridian", &latlng.LatLng{Latitude: -16.5, Longitude: 179.99}, 8000},
+		{"pole", &latlng.LatLng{Latitude: 89.99, Longitude: 45}, 3000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cells := radiusBox(tt.center, tt.radiusMeters).geohashCells()
+			for bearing := 0.0; bearing < 360; bearing += 0.5 {
+				p := destination(tt.center, bearing, tt.radiusMeters*(1-1e-6))
+				if d := DistanceMeters(tt.center, p); d > tt.radiusMeters {
+					t.Fatalf("test point at bearing %v is %vm away, outside the %vm radius", bearing, d, tt.radiusMeters)
+				}
+				if !coveredBy(cells, p) {
+					t.Errorf("point %v,%v at bearing %v is inside the radius but no queried cell covers it", p.Latitude, p.Longitude, bearing)
+				}
+			}
+		})
+	}
+}
+
+func TestRadiusBoxReportedPoint(t *testing.T) {
+	center := &latlng.LatLng{Latitude: 42.346426, Longitude: 166.692336}
+	cells := radiusBox(center, 3014.2).geohashCells()
+	for bearing := 0.0; bearing < 360; bearing += 0.1 {
+		p := destination(center, bearing, 3013.86)
+		if !coveredBy(cells, p) {
+			t.Fatalf("point %v,%v 3013.86m away at bearing %v is not covered by %v", p.Latitude, p.Longitude, bearing, cells)
+		}
+	}
+}
+
+func coveredBy(cells []string, p *latlng.LatLng) bool {
+	hash := Geohash(p.Latitude, p.Longitude, maxGeohashPrecision)
+	for _, cell := range cells {
+		if strings.HasPrefix(hash, cell) {
+			return true
+		}
+	}
+	return false
+}
+
+type geoPlace struct {
+	Name  string         `firestore:"name"`
+	Point *latlng.LatLng `firestore:"point"`
+}
+
+type geoVenue struct {
+	Title string   `firestore:"title"`
+	Place geoPlace `firestore:"place"`
+}
+
+func TestGeohashesNestedField(t *testing.T) {
+	paris := &latlng.LatLng{Latitude: 48.8566, Longitude: 2.3522}
+	rome := &latlng.LatLng{Latitude: 41.9028, Longitude: 12.4964}
+	parisHash := Geohash(paris.Latitude, paris.Longitude, maxGeohashPrecision)
+	romeHash := Geohash(rome.Latitude, rome.Longitude, maxGeohashPrecision)
+
+	tests := []struct {
+		name      string
+		hashField string
+		create    interface{}
+		wantDoc   map[string]interface{}
+		update    []firestore.Update
+		want      []firestore.Update
+	}{
+		{
+			name:    "struct create then point update",
+			create:  geoVenue{Title: "Louvre", Place: geoPlace{Name: "Paris", Point: paris}},
+			wantDoc: map[string]interface{}{"title": "Louvre", "place": map[string]interface{}{"name": "Paris", "point": paris, "pointGeohash": parisHash}},
+			update:  []firestore.Update{{Path: "place.point", Value: rome}},
+			want: []firestore.Update{
+				{Path: "place.point", Value: rome},
+				{FieldPath: firestore.FieldPath{"place", "pointGeohash"}, Value: romeHash},
+			},
+		},
+		{
+			name:    "map create then field path update",
+			create:  map[string]interface{}{"place": map[string]interface{}{"point": paris}},
+			wantDoc: map[string]interface{}{"place": map[string]interface{}{"point": paris, "pointGeohash": parisHash}},
+			update:  []firestore.Update{{FieldPath: firestore.FieldPath{"place", "point"}, Value: rome}},
+			want: []firestore.Update{
+				{FieldPath: firestore.FieldPath{"place", "point"}, Value: rome},
+				{FieldPath: firestore.FieldPath{"place", "pointGeohash"}, Value: romeHash},
+			},
+		},
+		{
+			name:    "parent update carries the hash",
+			create:  geoVenue{Place: geoPlace{Point: paris}},
+			wantDoc: map[string]interface{}{"title": "", "place": map[string]interface{}{"name": "", "point": paris, "pointGeohash": parisHash}},
+			update:  []firestore.Update{{Path: "place", Value: geoPlace{Name: "Rome", Point: rome}}},
+			want: []firestore.Update{
+				{Path: "place", Value: map[string]interface{}{"name": "Rome", "point": rome, "pointGeohash": romeHash}},
+			},
+		},
+		{
+			name:      "parent update with hash elsewhere",
+			hashField: "hashes.place",
+			create:    geoVenue{Place: geoPlace{Point: paris}},
+			wantDoc:   map[string]interface{}{"title": "", "place": map[string]interface{}{"name": "", "point": paris}, "hashes": map[string]interface{}{"place": parisHash}},
+			update:    []firestore.Update{{Path: "place", Value: map[string]interface{}{"name": "Nowhere"}}},
+			want: []firestore.Update{
+				{Path: "place", Value: map[string]interface{}{"name": "Nowhere"}},
+				{FieldPath: firestore.FieldPath{"hashes", "place"}, Value: firestore.Delete},
+			},
+		},
+		{
+			name:    "point cleared",
+			create:  geoVenue{Title: "Unplaced"},
+			wantDoc: map[string]interface{}{"title": "Unplaced", "place": map[string]interface{}{"name": "", "point": (*latlng.LatLng)(nil)}},
+			update:  []firestore.Update{{Path: "place.point", Value: firestore.Delete}},
+			want: []firestore.Update{
+				{Path: "place.point", Value: firestore.Delete},
+				{FieldPath: firestore.FieldPath{"place", "pointGeohash"}, Value: firestore.Delete},
+			},
+		},
+		{
+			name:    "unrelated update",
+			create:  map[string]interface{}{"place": "somewhere"},
+			wantDoc: map[string]interface{}{"place": "somewhere"},
+			update:  []firestore.Update{{Path: "placeName", Value: "x"}},
+			want:    []firestore.Update{{Path: "placeName", Value: "x"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := (&GenericStore{}).WithGeoIndex("place.point", tt.hashField)
+
+			doc, err := store.withGeohashes(tt.create)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(doc, tt.wantDoc) {
+				t.Errorf("withGeohashes() = %#v, want %#v", doc, tt.wantDoc)
+			}
+
+			got, err := store.geohashUpdates(tt.update)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("geohashUpdates() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeohashesLeaveCallerDataUnchanged(t *testing.T) {
+	point := &latlng.LatLng{Latitude: 1, Longitude: 2}
+	place := map[string]interface{}{"point": point}
+	data := map[string]interface{}{"place": place}
+	store := (&GenericStore{}).WithGeoIndex("place.point", "")
+	if _, err := store.withGeohashes(data); err != nil {
+		t.Fatal(err)
+	}
+	if len(place) != 1 {
+		t.Errorf("withGeohashes() modified the caller's nested map: %v", place)
+	}
+
+	value := map[string]interface{}{"point": point}
+	updates := []firestore.Update{{Path: "place", Value: value}}
+	if _, err := store.geohashUpdates(updates); err != nil {
+		t.Fatal(err)
+	}
+	if len(value) != 1 || !reflect.DeepEqual(updates[0].Value, value) {
+		t.Errorf("geohashUpdates() modified the caller's update: %v", updates)
+	}
+}
@@ -0,0 +1,227 @@
+package firestore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/maxcraig112/go-crud/errs"
+)
+
+// IDGenerator returns the ID for a new document in store. data is the document being created, or
+// nil when IDs are generated ahead of time with GenerateNIDs.
+type IDGenerator func(ctx context.Context, store *GenericStore, data interface{}) (string, error)
+
+// maxSlugAttempts bounds how many suffixed slugs are tried before giving up.
+const maxSlugAttempts = 100
+
+// maxCreateAttempts bounds how many generated IDs CreateDoc tries when they turn out to be taken.
+const maxCreateAttempts = 5
+
+type reservedIDsKey struct{}
+
+// withReservedIDs returns a context recording the IDs handed out by newID, so generators such as
+// SlugIDs skip IDs already issued earlier in the same batch or found to be taken.
+func withReservedIDs(ctx context.Context, reserved map[string]bool) context.Context {
+	return context.WithValue(ctx, reservedIDsKey{}, reserved)
+}
+
+// idReserved reports whether id has already been handed out under ctx.
+func idReserved(ctx context.Context, id string) bool {
+	reserved, _ := ctx.Value(reservedIDsKey{}).(map[string]bool)
+	return reserved[id]
+}
+
+// WithIDGenerator sets how the store generates IDs for new documents. By default Firestore's random
+// auto-IDs are used.
+func (s *GenericStore) WithIDGenerator(gen IDGenerator) *GenericStore {
+	s.idGenerator = gen
+	return s
+}
+
+// newID returns an ID for data from the store's generator, or a Firestore auto-ID if none is set.
+// IDs are reserved in ctx when it carries a reservation set, and a generator that repeats a reserved ID
+// fails with ErrAlreadyExists.
+func (s *GenericStore) newID(ctx context.Context, data interface{}) (string, error) {
+	if s.idGenerator == nil {
+		return s.collection.NewDoc().ID, nil
+	}
+	id, err := s.idGenerator(ctx, s, data)
+	if err != nil {
+		return "", err
+	}
+	reserved, ok := ctx.Value(reservedIDsKey{}).(map[string]bool)
+	if ok {
+		if reserved[id] {
+			return "", ErrAlreadyExists
+		}
+		reserved[id] = true
+	}
+	return id, nil
+}
+
+// FirestoreIDs generates Firestore's random 20 character auto-IDs.
+func FirestoreIDs() IDGenerator {
+	return func(ctx context.Context, store *GenericStore, data interface{}) (string, error) {
+		return store.collection.NewDoc().ID, nil
+	}
+}
+
+// ULIDs generates lexicographically sortable ULIDs.
+func ULIDs() IDGenerator {
+	return func(ctx context.Context, store *GenericStore, data interface{}) (string, error) {
+		return newULID(time.Now())
+	}
+}
+
+// UUIDv7s generates time-ordered version 7 UUIDs.
+func UUIDv7s() IDGenerator {
+	return func(ctx context.Context, store *GenericStore, data interface{}) (string, error) {
+		id, err := uuid.NewV7()
+		if err != nil {
+			return "", errs.Wrap(errs.Internal, err, "failed to generate UUIDv7")
+		}
+		return id.String(), nil
+	}
+}
+
+// TimeSortableIDs generates 20 character IDs, the same length as Firestore auto-IDs, whose first nine
+// characters encode the creation time in milliseconds so IDs sort by creation order.
+func TimeSortableIDs() IDGenerator {
+	return func(ctx context.Context, store *GenericStore, data interface{}) (string, error) {
+		ts := strconv.FormatInt(time.Now().UnixMilli(), 36)
+		suffix, err := randomString(lowerAlphanumeric, 20-9)
+		if err != nil {
+			return "", err
+		}
+		return strings.Repeat("0", 9-len(ts)) + ts + suffix, nil
+	}
+}
+
+// SlugIDs generates human-readable IDs from a string field of the document, e.g. "Hello World" becomes
+// "hello-world". If the slug is already taken, or was issued earlier in the same batch, a numeric suffix
+// is added: "hello-world-2".
+func SlugIDs(field string) IDGenerator {
+	return func(ctx context.Context, store *GenericStore, data interface{}) (string, error) {
+		value, ok := fieldString(data, field)
+		if !ok {
+			return "", errs.Newf(errs.Invalid, "document has no string field %q to build a slug from", field)
+		}
+		base := slugify(value)
+		if base == "" {
+			return "", errs.Newf(errs.Invalid, "field %q does not contain any slug characters", field)
+		}
+
+		for attempt := 1; attempt <= maxSlugAttempts; attempt++ {
+			id := base
+			if attempt > 1 {
+				id = base + "-" + strconv.Itoa(attempt)
+			}
+			if idReserved(ctx, id) {
+				continue
+			}
+			_, err := store.collection.Doc(id).Get(ctx)
+			if status.Code(err) == codes.NotFound {
+				return id, nil
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+		return "", errs.Newf(errs.Conflict, "no free slug found for %q", base)
+	}
+}
+
+// slugify lowercases s and replaces every run of non-alphanumeric characters with a single hyphen.
+func slugify(s string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			hyphen = false
+		} else if !hyphen && b.Len() > 0 {
+			b.WriteByte('-')
+			hyphen = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// fieldString reads a top level string field from a map or Firestore-tagged struct.
+func fieldString(data interface{}, field string) (string, bool) {
+	if m, ok := data.(map[string]interface{}); ok {
+		s, ok := m[field].(string)
+		return s, ok
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(data))
+	if v.Kind() != reflect.Struct {
+		return "", false
+	}
+	for _, f := range structFields(v.Type()) {
+		if f.name == field {
+			fv := v.FieldByIndex(f.index)
+			if fv.Kind() != reflect.String {
+				return "", false
+			}
+			return fv.String(), true
+		}
+	}
+	return "", false
+}
+
+const (
+	crockfordBase32   = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	lowerAlphanumeric = "0123456789abcdefghijklmnopqrstuvwxyz"
+)
+
+// newULID encodes a 48 bit millisecond timestamp and 80 random bits as 26 Crockford base32 characters.
+func newULID(t time.Time) (string, error) {
+	var id [16]byte
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(t.UnixMilli()))
+	copy(id[:6], ts[2:])
+	if _, err := rand.Read(id[6:]); err != nil {
+		return "", errs.Wrap(errs.Internal, err, "failed to generate ULID")
+	}
+
+	// 128 bits is encoded as 26 five bit groups, the first holding only 3 bits
+	out := make([]byte, 26)
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+	for i := 25; i >= 0; i-- {
+		out[i] = crockfordBase32[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out), nil
+}
+
+// randomString returns n characters drawn uniformly from alphabet.
+func randomString(alphabet string, n int) (string, error) {
+	// reject bytes past the largest multiple of the alphabet size to avoid modulo bias
+	limit := 256 - 256%len(alphabet)
+	out := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(out) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return "", errs.Wrap(errs.Internal, err, "failed to generate random ID")
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(out) < n {
+				out = append(out, alphabet[int(b)%len(alphabet)])
+			}
+		}
+	}
+	return string(out), nil
+}
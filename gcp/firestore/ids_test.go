@@ -0,0 +1,206 @@
+package firestore
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Hello World", "hello-world"},
+		{"  Leading and trailing  ", "leading-and-trailing"},
+		{"a--b__c", "a-b-c"},
+		{"Crème Brûlée", "crème-brûlée"},
+		{"Version 2.0!", "version-2-0"},
+		{"!!!", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := slugify(tt.in); got != tt.want {
+				t.Errorf("slugify(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFieldString(t *testing.T) {
+	type named struct {
+		Title string `firestore:"title"`
+		Count int    `firestore:"count"`
+	}
+	tests := []struct {
+		name   string
+		data   interface{}
+		field  string
+		want   string
+		wantOK bool
+	}{
+		{"map", map[string]interface{}{"title": "x"}, "title", "x", true},
+		{"map non-string", map[string]interface{}{"title": 1}, "title", "", false},
+		{"map missing", map[string]interface{}{}, "title", "", false},
+		{"struct tag", named{Title: "y"}, "title", "y", true},
+		{"struct pointer", &named{Title: "z"}, "title", "z", true},
+		{"struct non-string", named{Count: 1}, "count", "", false},
+		{"struct Go name", named{Title: "y"}, "Title", "", false},
+		{"nil", nil, "title", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := fieldString(tt.data, tt.field)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("fieldString() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestULID(t *testing.T) {
+	earlier := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		t    time.Time
+	}{
+		{"epoch", time.Unix(0, 0)},
+		{"now", time.Now()},
+		{"max timestamp", time.UnixMilli(1<<48 - 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := newULID(tt.t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(id) != 26 {
+				t.Fatalf("ULID %q has length %d, want 26", id, len(id))
+			}
+			if id[0] > '7' {
+				t.Errorf("ULID %q overflows 128 bits", id)
+			}
+			for _, r := range id {
+				if !strings.ContainsRune(crockfordBase32, r) {
+					t.Errorf("ULID %q contains %q, which is not Crockford base32", id, r)
+				}
+			}
+		})
+	}
+
+	a, _ := newULID(earlier)
+	b, _ := newULID(earlier.Add(time.Millisecond))
+	if a >= b {
+		t.Errorf("ULID %q for an earlier time does not sort before %q", a, b)
+	}
+	if got := a[:10]; got != "01HK153X00" {
+		t.Errorf("ULID timestamp for %v = %q, want 01HK153X00", earlier, got)
+	}
+}
+
+func TestTimeSortableIDs(t *testing.T) {
+	gen := TimeSortableIDs()
+	first, err := gen(context.Background(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	second, err := gen(context.Background(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{first, second} {
+		if len(id) != 20 {
+			t.Errorf("ID %q has length %d, want 20", id, len(id))
+		}
+		if strings.Trim(id, lowerAlphanumeric) != "" {
+			t.Errorf("ID %q is not lowercase alphanumeric", id)
+		}
+	}
+	if first[:9] >= second[:9] {
+		t.Errorf("ID %q generated first does not sort before %q", first, second)
+	}
+}
+
+func TestRandomString(t *testing.T) {
+	tests := []struct {
+		name     string
+		alphabet string
+		n        int
+	}{
+		{"lower alphanumeric", lowerAlphanumeric, 64},
+		{"crockford", crockfordBase32, 64},
+		{"single character", "x", 8},
+		{"empty", lowerAlphanumeric, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := randomString(tt.alphabet, tt.n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(s) != tt.n {
+				t.Errorf("randomString() has length %d, want %d", len(s), tt.n)
+			}
+			if strings.Trim(s, tt.alphabet) != "" {
+				t.Errorf("randomString() = %q, which uses characters outside %q", s, tt.alphabet)
+			}
+		})
+	}
+}
+
+func TestGenerateNIDs(t *testing.T) {
+	sequence := func(ids ...string) IDGenerator {
+		i := 0
+		return func(ctx context.Context, store *GenericStore, data interface{}) (string, error) {
+			id := ids[i%len(ids)]
+			i++
+			return id, nil
+		}
+	}
+	tests := []struct {
+		name    string
+		gen     IDGenerator
+		n       int
+		want    []string
+		wantErr error
+	}{
+		{"distinct", sequence("a", "b", "c"), 3, []string{"a", "b", "c"}, nil},
+		{"none", sequence("a"), 0, []string{}, nil},
+		{"repeated", sequence("a", "b", "a"), 3, nil, ErrAlreadyExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := (&GenericStore{}).WithIDGenerator(tt.gen)
+			got, err := store.GenerateNIDs(context.Background(), tt.n)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GenerateNIDs() error = %v, want %v", err, tt.wantErr)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("GenerateNIDs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateNIDsReservesIDs(t *testing.T) {
+	// a generator consulting the reservation set sees the IDs issued earlier in the call
+	gen := func(ctx context.Context, store *GenericStore, data interface{}) (string, error) {
+		for i := 1; ; i++ {
+			id := "id-" + strconv.Itoa(i)
+			if !idReserved(ctx, id) {
+				return id, nil
+			}
+		}
+	}
+	got, err := (&GenericStore{}).WithIDGenerator(gen).GenerateNIDs(context.Background(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "id-1,id-2,id-3"; strings.Join(got, ",") != want {
+		t.Errorf("GenerateNIDs() = %v, want %s", got, want)
+	}
+}
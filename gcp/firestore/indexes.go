@@ -0,0 +1,265 @@
+package firestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/maxcraig112/go-crud/errs"
+)
+
+// MissingIndexError is returned when a query needs a composite index that does not exist.
+type MissingIndexError struct {
+	Collection string
+	// Query is the shape of the failing query, e.g. "status == ?, createdAt > ?".
+	Query string
+	// CreateURL is the console link Firestore provides to create the missing index.
+	CreateURL string
+	Err       error
+}
+
+func (e *MissingIndexError) Error() string {
+	return fmt.Sprintf("query on %s (%s) requires a composite index, create it at %s", e.Collection, e.Query, e.CreateURL)
+}
+
+func (e *MissingIndexError) Unwrap() error { return e.Err }
+
+var indexURLPattern = regexp.MustCompile(`https://console\.firebase\.google\.com/\S+`)
+
+// indexError converts the FailedPrecondition returned for queries lacking a composite index into an
+// Invalid MissingIndexError, since retrying the query cannot succeed until the index is created. Any
+// other error is returned unchanged.
+func (s *GenericStore) indexError(query []QueryParameter, err error) error {
+	if status.Code(err) != codes.FailedPrecondition {
+		return err
+	}
+	url := indexURLPattern.FindString(status.Convert(err).Message())
+	if url == "" {
+		return err
+	}
+	return errs.Wrap(errs.Invalid, &MissingIndexError{
+		Collection: s.collection.ID,
+		Query:      queryShape(query),
+		CreateURL:  url,
+		Err:        err,
+	}, "")
+}
+
+// IndexField is a field of a composite index.
+type IndexField struct {
+	FieldPath    string        `json:"fieldPath"`
+	Order        string        `json:"order,omitempty"`
+	ArrayConfig  string        `json:"arrayConfig,omitempty"`
+	VectorConfig *VectorConfig `json:"vectorConfig,omitempty"`
+}
+
+// VectorConfig marks the vector field of an index used by FindNearest.
+type VectorConfig struct {
+	Dimension int      `json:"dimension"`
+	Flat      struct{} `json:"flat"`
+}
+
+// CompositeIndex is an index definition in the firestore.indexes.json format.
+type CompositeIndex struct {
+	CollectionGroup string       `json:"collectionGroup"`
+	QueryScope      string       `json:"queryScope"`
+	Fields          []IndexField `json:"fields"`
+}
+
+// key identifies an index regardless of how it was declared.
+func (i CompositeIndex) key() string {
+	parts := []string{i.CollectionGroup, i.QueryScope}
+	for _, f := range i.Fields {
+		part := f.FieldPath + ":" + f.Order + ":" + f.ArrayConfig
+		if f.VectorConfig != nil {
+			part += ":vector" + strconv.Itoa(f.VectorConfig.Dimension)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "|")
+}
+
+// IndexesFile is the structure of a firestore.indexes.json file.
+type IndexesFile struct {
+	Indexes        []CompositeIndex  `json:"indexes"`
+	FieldOverrides []json.RawMessage `json:"fieldOverrides"`
+}
+
+// OrderBy is an ordering applied to a declared query.
+type OrderBy struct {
+	Path      string
+	Direction firestore.Direction
+}
+
+// IndexRegistry records the query shapes a service runs so the composite indexes they need can be
+// generated and checked before deploying.
+type IndexRegistry struct {
+	mu      sync.Mutex
+	indexes map[string]CompositeIndex
+}
+
+// NewIndexRegistry returns an empty registry.
+func NewIndexRegistry() *IndexRegistry {
+	return &IndexRegistry{indexes: make(map[string]CompositeIndex)}
+}
+
+// WithIndexRegistry records every query the store runs in registry.
+func (s *GenericStore) WithIndexRegistry(registry *IndexRegistry) *GenericStore {
+	s.indexRegistry = registry
+	return s
+}
+
+// trackQuery records a query in the store's index registry, if it has one.
+func (s *GenericStore) trackQuery(query []QueryParameter, orderBy ...OrderBy) {
+	if s.indexRegistry != nil {
+		s.indexRegistry.Declare(s.collection.ID, query, orderBy...)
+	}
+}
+
+// trackVectorQuery records a FindNearest query in the store's index registry, if it has one.
+func (s *GenericStore) trackVectorQuery(query []QueryParameter, vectorField string, dimension int) {
+	if s.indexRegistry != nil {
+		s.indexRegistry.DeclareVector(s.collection.ID, query, vectorField, dimension)
+	}
+}
+
+// Declare records a query on collection. Query values are ignored; only the filtered fields,
+// operators and orderings determine the index. Queries served by Firestore's automatic single-field
+// indexes are not recorded.
+func (r *IndexRegistry) Declare(collection string, query []QueryParameter, orderBy ...OrderBy) {
+	index, ok := compositeIndexFor(collection, query, orderBy)
+	if !ok {
+		return
+	}
+	r.add(index)
+}
+
+// DeclareVector records a nearest-neighbour query on collection over vectorField, holding vectors of
+// the given dimension, pre-filtered by query. Vector queries always need a composite index, with the
+// vector field last.
+func (r *IndexRegistry) DeclareVector(collection string, query []QueryParameter, vectorField string, dimension int) {
+	fields, _ := indexFields(query, nil)
+	fields = append(fields, IndexField{FieldPath: vectorField, VectorConfig: &VectorConfig{Dimension: dimension}})
+	r.add(CompositeIndex{CollectionGroup: collection, QueryScope: "COLLECTION", Fields: fields})
+}
+
+func (r *IndexRegistry) add(index CompositeIndex) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.indexes[index.key()] = index
+}
+
+// Indexes returns the composite indexes needed by every declared query.
+func (r *IndexRegistry) Indexes() []CompositeIndex {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := make([]string, 0, len(r.indexes))
+	for key := range r.indexes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	out := make([]CompositeIndex, len(keys))
+	for i, key := range keys {
+		out[i] = r.indexes[key]
+	}
+	return out
+}
+
+// WriteIndexesJSON writes the needed indexes as a firestore.indexes.json file.
+func (r *IndexRegistry) WriteIndexesJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(IndexesFile{Indexes: r.Indexes(), FieldOverrides: []json.RawMessage{}})
+}
+
+// Missing returns the needed indexes that are not defined in an existing firestore.indexes.json file.
+func (r *IndexRegistry) Missing(indexesJSON io.Reader) ([]CompositeIndex, error) {
+	var file IndexesFile
+	if err := json.NewDecoder(indexesJSON).Decode(&file); err != nil {
+		return nil, errs.Wrap(errs.Invalid, err, "invalid firestore.indexes.json")
+	}
+
+	existing := make(map[string]bool, len(file.Indexes))
+	for _, index := range file.Indexes {
+		existing[index.key()] = true
+	}
+
+	var missing []CompositeIndex
+	for _, index := range r.Indexes() {
+		if !existing[index.key()] {
+			missing = append(missing, index)
+		}
+	}
+	return missing, nil
+}
+
+// compositeIndexFor derives the composite index a query needs: equality filters first, sorted by
+// path, then array-contains filters, inequality filters and explicit orderings. It reports false when
+// single-field indexes suffice, which includes queries made only of equality filters since Firestore
+// merges their single-field indexes.
+func compositeIndexFor(collection string, query []QueryParameter, orderBy []OrderBy) (CompositeIndex, bool) {
+	fields, equality := indexFields(query, orderBy)
+	if len(fields) < 2 || len(fields) == equality {
+		return CompositeIndex{}, false
+	}
+	return CompositeIndex{CollectionGroup: collection, QueryScope: "COLLECTION", Fields: fields}, true
+}
+
+// indexFields returns the index fields of a query in the order described by compositeIndexFor, and
+// the number of leading equality fields.
+func indexFields(query []QueryParameter, orderBy []OrderBy) ([]IndexField, int) {
+	var equality, arrays, inequality []IndexField
+	seen := make(map[string]bool)
+
+	for _, q := range query {
+		switch q.Op {
+		case "==", "in":
+			if !seen[q.Path] {
+				equality = append(equality, IndexField{FieldPath: q.Path, Order: "ASCENDING"})
+			}
+		case "array-contains", "array-contains-any":
+			if !seen[q.Path] {
+				arrays = append(arrays, IndexField{FieldPath: q.Path, ArrayConfig: "CONTAINS"})
+			}
+		default:
+			if !seen[q.Path] {
+				inequality = append(inequality, IndexField{FieldPath: q.Path, Order: "ASCENDING"})
+			}
+		}
+		seen[q.Path] = true
+	}
+	sort.Slice(equality, func(i, j int) bool { return equality[i].FieldPath < equality[j].FieldPath })
+
+	fields := append(append(equality, arrays...), inequality...)
+	ordered := len(equality) + len(arrays)
+	for _, o := range orderBy {
+		order := "ASCENDING"
+		if o.Direction == firestore.Desc {
+			order = "DESCENDING"
+		}
+		replaced := false
+		for i := ordered; i < len(fields); i++ {
+			if fields[i].FieldPath == o.Path {
+				fields[i].Order = order
+				replaced = true
+			}
+		}
+		if !replaced && !seen[o.Path] {
+			fields = append(fields, IndexField{FieldPath: o.Path, Order: order})
+			seen[o.Path] = true
+		}
+	}
+
+	return fields, len(equality)
+}
@@ -0,0 +1,166 @@
+package firestore
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+)
+
+func TestCompositeIndexFor(t *testing.T) {
+	asc := func(path string) IndexField { return IndexField{FieldPath: path, Order: "ASCENDING"} }
+	desc := func(path string) IndexField { return IndexField{FieldPath: path, Order: "DESCENDING"} }
+	contains := func(path string) IndexField { return IndexField{FieldPath: path, ArrayConfig: "CONTAINS"} }
+
+	tests := []struct {
+		name    string
+		query   []QueryParameter
+		orderBy []OrderBy
+		want    []IndexField
+	}{
+		{
+			name:  "single field",
+			query: []QueryParameter{{Path: "age", Op: ">", Value: 1}},
+		},
+		{
+			name:  "equality only",
+			query: []QueryParameter{{Path: "b", Op: "==", Value: 1}, {Path: "a", Op: "in", Value: []int{1}}},
+		},
+		{
+			name:    "order by a single field",
+			orderBy: []OrderBy{{Path: "createdAt", Direction: firestore.Desc}},
+		},
+		{
+			name:  "equality sorted by path then inequality",
+			query: []QueryParameter{{Path: "status", Op: "==", Value: "x"}, {Path: "age", Op: ">=", Value: 1}, {Path: "city", Op: "==", Value: "y"}},
+			want:  []IndexField{asc("city"), asc("status"), asc("age")},
+		},
+		{
+			name:  "array-contains after equality",
+			query: []QueryParameter{{Path: "tags", Op: "array-contains", Value: "a"}, {Path: "status", Op: "==", Value: "x"}},
+			want:  []IndexField{asc("status"), contains("tags")},
+		},
+		{
+			name:    "descending order replaces inequality order",
+			query:   []QueryParameter{{Path: "status", Op: "==", Value: "x"}, {Path: "age", Op: "<", Value: 1}},
+			orderBy: []OrderBy{{Path: "age", Direction: firestore.Desc}},
+			want:    []IndexField{asc("status"), desc("age")},
+		},
+		{
+			name:    "order by after inequality",
+			query:   []QueryParameter{{Path: "age", Op: ">", Value: 1}},
+			orderBy: []OrderBy{{Path: "name", Direction: firestore.Asc}},
+			want:    []IndexField{asc("age"), asc("name")},
+		},
+		{
+			name:    "order by equality field is ignored",
+			query:   []QueryParameter{{Path: "status", Op: "==", Value: "x"}},
+			orderBy: []OrderBy{{Path: "status", Direction: firestore.Desc}},
+		},
+		{
+			name:    "equality with order by",
+			query:   []QueryParameter{{Path: "status", Op: "==", Value: "x"}},
+			orderBy: []OrderBy{{Path: "createdAt", Direction: firestore.Desc}},
+			want:    []IndexField{asc("status"), desc("createdAt")},
+		},
+		{
+			name:  "repeated field",
+			query: []QueryParameter{{Path: "age", Op: ">", Value: 1}, {Path: "age", Op: "<", Value: 9}, {Path: "status", Op: "==", Value: "x"}},
+			want:  []IndexField{asc("status"), asc("age")},
+		},
+		{
+			name:  "range on two fields",
+			query: []QueryParameter{{Path: "price", Op: "<", Value: 1}, {Path: "age", Op: "!=", Value: 9}},
+			want:  []IndexField{asc("price"), asc("age")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := compositeIndexFor("users", tt.query, tt.orderBy)
+			if tt.want == nil {
+				if ok {
+					t.Errorf("compositeIndexFor() = %v, want no composite index", got.Fields)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("compositeIndexFor() reported no index, want %v", tt.want)
+			}
+			if got.CollectionGroup != "users" || got.QueryScope != "COLLECTION" {
+				t.Errorf("compositeIndexFor() scope = %s %s, want users COLLECTION", got.CollectionGroup, got.QueryScope)
+			}
+			if !reflect.DeepEqual(got.Fields, tt.want) {
+				t.Errorf("compositeIndexFor() fields = %v, want %v", got.Fields, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndexRegistryMissing(t *testing.T) {
+	registry := NewIndexRegistry()
+	registry.Declare("users", []QueryParameter{{Path: "status", Op: "==", Value: "a"}, {Path: "age", Op: ">", Value: 1}})
+	registry.Declare("users", []QueryParameter{{Path: "status", Op: "==", Value: "b"}, {Path: "age", Op: ">", Value: 2}})
+	registry.Declare("posts", []QueryParameter{{Path: "author", Op: "==", Value: "x"}}, OrderBy{Path: "createdAt", Direction: firestore.Desc})
+	registry.Declare("posts", []QueryParameter{{Path: "author", Op: "==", Value: "x"}})
+
+	if got := len(registry.Indexes()); got != 2 {
+		t.Fatalf("registry has %d indexes, want 2 with query values ignored", got)
+	}
+
+	var buf bytes.Buffer
+	if err := registry.WriteIndexesJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	missing, err := registry.Missing(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 0 {
+		t.Errorf("Missing() against the registry's own file = %v, want none", missing)
+	}
+
+	existing := `{"indexes":[{"collectionGroup":"posts","queryScope":"COLLECTION","fields":[
+		{"fieldPath":"author","order":"ASCENDING"},{"fieldPath":"createdAt","order":"DESCENDING"}]}]}`
+	missing, err = registry.Missing(strings.NewReader(existing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 1 || missing[0].CollectionGroup != "users" {
+		t.Errorf("Missing() = %v, want only the users index", missing)
+	}
+
+	if _, err := registry.Missing(strings.NewReader("not json")); err == nil {
+		t.Error("Missing() accepted an invalid file")
+	}
+}
+
+func TestIndexRegistryDeclareVector(t *testing.T) {
+	registry := NewIndexRegistry()
+	registry.DeclareVector("docs", nil, "embedding", 3)
+	registry.DeclareVector("docs", []QueryParameter{{Path: "lang", Op: "==", Value: "en"}}, "embedding", 3)
+	registry.DeclareVector("docs", nil, "embedding", 768)
+
+	var buf bytes.Buffer
+	if err := registry.WriteIndexesJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(strings.Fields(buf.String()), "")
+	want := `{"indexes":[` +
+		`{"collectionGroup":"docs","queryScope":"COLLECTION","fields":[{"fieldPath":"embedding","vectorConfig":{"dimension":3,"flat":{}}}]},` +
+		`{"collectionGroup":"docs","queryScope":"COLLECTION","fields":[{"fieldPath":"embedding","vectorConfig":{"dimension":768,"flat":{}}}]},` +
+		`{"collectionGroup":"docs","queryScope":"COLLECTION","fields":[{"fieldPath":"lang","order":"ASCENDING"},{"fieldPath":"embedding","vectorConfig":{"dimension":3,"flat":{}}}]}` +
+		`],"fieldOverrides":[]}`
+	if got != want {
+		t.Errorf("WriteIndexesJSON() =\n%s\nwant\n%s", got, want)
+	}
+
+	missing, err := registry.Missing(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 0 {
+		t.Errorf("Missing() against the registry's own file = %v, want none", missing)
+	}
+}
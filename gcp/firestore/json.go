@@ -0,0 +1,378 @@
+package firestore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/genproto/googleapis/type/latlng"
+
+	"github.com/maxcraig112/go-crud/errs"
+)
+
+// RefEncoding selects how document references are written in plain JSON.
+type RefEncoding int
+
+const (
+	// RefPath writes references as their path relative to the database, e.g. "users/abc".
+	RefPath RefEncoding = iota
+	// RefID writes references as the referenced document's ID.
+	RefID
+)
+
+// JSONOptions configures how Firestore values are represented in JSON.
+type JSONOptions struct {
+	// Plain writes Firestore-native values as ordinary JSON values for API clients. Plain JSON is
+	// lossy: timestamps, references and bytes cannot be told apart from strings when decoded. By
+	// default values are written as tagged objects, e.g. {"$timestamp": "..."}, which decode losslessly;
+	// whole-number doubles are tagged so they do not decode as integers, and data keys starting with
+	// "$" are escaped with a second "$".
+	Plain bool
+	// TimeFormat is the layout used for timestamps. Defaults to time.RFC3339Nano.
+	TimeFormat string
+	// Refs selects how references are written in plain JSON.
+	Refs RefEncoding
+}
+
+// Tags identifying Firestore-native values in tagged JSON.
+const (
+	timestampTag = "$timestamp"
+	refTag       = "$ref"
+	geopointTag  = "$geopoint"
+	bytesTag     = "$bytes"
+	vectorTag    = "$vector"
+	vector32Tag  = "$vector32"
+	doubleTag    = "$double"
+)
+
+// DocumentJSON is the JSON representation of a document snapshot.
+type DocumentJSON struct {
+	ID         string                 `json:"id"`
+	Path       string                 `json:"path"`
+	CreateTime string                 `json:"createTime,omitempty"`
+	UpdateTime string                 `json:"updateTime,omitempty"`
+	Data       map[string]interface{} `json:"data"`
+}
+
+// JSONCodec converts documents to JSON and JSON back into Firestore-native values.
+type JSONCodec struct {
+	client FirestoreClientInterface
+	opts   JSONOptions
+}
+
+// NewJSONCodec returns a codec using client to resolve decoded document references.
+func NewJSONCodec(client FirestoreClientInterface, opts JSONOptions) *JSONCodec {
+	if opts.TimeFormat == "" {
+		opts.TimeFormat = time.RFC3339Nano
+	}
+	return &JSONCodec{client: client, opts: opts}
+}
+
+// EncodeDoc converts a document snapshot into its JSON representation.
+func (c *JSONCodec) EncodeDoc(doc *firestore.DocumentSnapshot) DocumentJSON {
+	out := DocumentJSON{
+		ID:   doc.Ref.ID,
+		Path: relativePath(doc.Ref),
+		Data: c.encodeValue(doc.Data()).(map[string]interface{}),
+	}
+	if !doc.CreateTime.IsZero() {
+		out.CreateTime = doc.CreateTime.Format(c.opts.TimeFormat)
+	}
+	if !doc.UpdateTime.IsZero() {
+		out.UpdateTime = doc.UpdateTime.Format(c.opts.TimeFormat)
+	}
+	return out
+}
+
+// MarshalDoc encodes a document snapshot as JSON.
+func (c *JSONCodec) MarshalDoc(doc *firestore.DocumentSnapshot) ([]byte, error) {
+	return json.Marshal(c.EncodeDoc(doc))
+}
+
+// MarshalDocs encodes documents, such as the results of ReadCollection, as a JSON array.
+func (c *JSONCodec) MarshalDocs(docs []*firestore.DocumentSnapshot) ([]byte, error) {
+	out := make([]DocumentJSON, len(docs))
+	for i, doc := range docs {
+		out[i] = c.EncodeDoc(doc)
+	}
+	return json.Marshal(out)
+}
+
+func (c *JSONCodec) encodeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			if !c.opts.Plain && strings.HasPrefix(k, "$") {
+				k = "$" + k
+			}
+			out[k] = c.encodeValue(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = c.encodeValue(item)
+		}
+		return out
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return c.tagged(doubleTag, formatSpecialFloat(v))
+		}
+		if v == math.Trunc(v) {
+			// a whole-number double would otherwise decode as an integer
+			return c.tagged(doubleTag, v)
+		}
+		return v
+	case time.Time:
+		return c.tagged(timestampTag, v.Format(c.opts.TimeFormat))
+	case *firestore.DocumentRef:
+		if v == nil {
+			return nil
+		}
+		if c.opts.Plain && c.opts.Refs == RefID {
+			return v.ID
+		}
+		return c.tagged(refTag, relativePath(v))
+	case *latlng.LatLng:
+		if v == nil {
+			return nil
+		}
+		return c.tagged(geopointTag, map[string]interface{}{"latitude": v.Latitude, "longitude": v.Longitude})
+	case []byte:
+		return c.tagged(bytesTag, base64.StdEncoding.EncodeToString(v))
+	case firestore.Vector64:
+		return c.tagged(vectorTag, []float64(v))
+	case firestore.Vector32:
+		return c.tagged(vector32Tag, []float32(v))
+	}
+	return value
+}
+
+func (c *JSONCodec) tagged(tag string, value interface{}) interface{} {
+	if c.opts.Plain {
+		return value
+	}
+	return map[string]interface{}{tag: value}
+}
+
+// DecodeData decodes a JSON object into Firestore-native values ready to be written, converting
+// tagged values back into timestamps, references, geopoints, bytes and vectors, and untagged whole
+// numbers into integers. Keys starting with "$$" are unescaped to start with a single "$".
+func (c *JSONCodec) DecodeData(data []byte) (map[string]interface{}, error) {
+	var raw map[string]interface{}
+	if err := decodeJSON(data, &raw); err != nil {
+		return nil, err
+	}
+	decoded, err := c.decodeValue(raw)
+	if err != nil {
+		return nil, err
+	}
+	return decoded.(map[string]interface{}), nil
+}
+
+// DecodeMap converts values that are already parsed, such as a document read from a YAML file, into
+// Firestore-native values ready to be written. Tagged values are decoded as by DecodeData, and Go
+// ints are converted to int64.
+func (c *JSONCodec) DecodeMap(data map[string]interface{}) (map[string]interface{}, error) {
+	decoded, err := c.decodeValue(data)
+	if err != nil {
+		return nil, err
+	}
+	return decoded.(map[string]interface{}), nil
+}
+
+func (c *JSONCodec) decodeValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 1 {
+			for tag, tagged := range v {
+				if strings.HasPrefix(tag, "$") && !strings.HasPrefix(tag, "$$") {
+					return c.decodeTagged(tag, tagged)
+				}
+			}
+		}
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			decoded, err := c.decodeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			if strings.HasPrefix(k, "$$") {
+				k = k[1:]
+			}
+			out[k] = decoded
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			decoded, err := c.decodeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = decoded
+		}
+		return out, nil
+	case int:
+		return int64(v), nil
+	}
+	return value, nil
+}
+
+func (c *JSONCodec) decodeTagged(tag string, value interface{}) (interface{}, error) {
+	invalid := func() error {
+		return errs.Newf(errs.Invalid, "invalid %s value: %v", tag, value)
+	}
+
+	switch tag {
+	case timestampTag:
+		s, ok := value.(string)
+		if !ok {
+			return nil, invalid()
+		}
+		t, err := time.Parse(c.opts.TimeFormat, s)
+		if err != nil {
+			return nil, errs.Wrap(errs.Invalid, err, "invalid "+tag+" value")
+		}
+		return t, nil
+	case refTag:
+		s, ok := value.(string)
+		if !ok {
+			return nil, invalid()
+		}
+		ref := c.docRef(s)
+		if ref == nil {
+			return nil, invalid()
+		}
+		return ref, nil
+	case geopointTag:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, invalid()
+		}
+		lat, latOK := toFloat(m["latitude"])
+		lng, lngOK := toFloat(m["longitude"])
+		if !latOK || !lngOK {
+			return nil, invalid()
+		}
+		return &latlng.LatLng{Latitude: lat, Longitude: lng}, nil
+	case bytesTag:
+		s, ok := value.(string)
+		if !ok {
+			return nil, invalid()
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, errs.Wrap(errs.Invalid, err, "invalid "+tag+" value")
+		}
+		return b, nil
+	case vectorTag:
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, invalid()
+		}
+		vector := make(firestore.Vector64, len(items))
+		for i, item := range items {
+			if vector[i], ok = toFloat(item); !ok {
+				return nil, invalid()
+			}
+		}
+		return vector, nil
+	case vector32Tag:
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, invalid()
+		}
+		vector := make(firestore.Vector32, len(items))
+		for i, item := range items {
+			f, ok := toFloat(item)
+			if !ok {
+				return nil, invalid()
+			}
+			vector[i] = float32(f)
+		}
+		return vector, nil
+	case doubleTag:
+		if f, ok := toFloat(value); ok {
+			return f, nil
+		}
+		s, ok := value.(string)
+		if !ok {
+			return nil, invalid()
+		}
+		return parseSpecialFloat(s)
+	}
+	return nil, errs.Newf(errs.Invalid, "unknown JSON value tag %q", tag)
+}
+
+// docRef builds a reference from a path relative to the database, e.g. "users/abc/posts/xyz".
+func (c *JSONCodec) docRef(path string) *firestore.DocumentRef {
+	return docRefAt(c.client, path)
+}
+
+// docRefAt builds a reference in client's database from a path relative to the database.
+func docRefAt(client FirestoreClientInterface, path string) *firestore.DocumentRef {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) < 2 || len(segments)%2 != 0 {
+		return nil
+	}
+	ref := client.GetCollection(segments[0]).Doc(segments[1])
+	for i := 2; i < len(segments); i += 2 {
+		ref = ref.Collection(segments[i]).Doc(segments[i+1])
+	}
+	return ref
+}
+
+// relativePath returns a reference's path relative to its database.
+func relativePath(ref *firestore.DocumentRef) string {
+	if _, path, ok := strings.Cut(ref.Path, "/documents/"); ok {
+		return path
+	}
+	return ref.Path
+}
+
+// CollectionPath returns a collection's path relative to its database, e.g. "users/abc/orders".
+func CollectionPath(coll *firestore.CollectionRef) string {
+	if coll.Parent == nil {
+		return coll.ID
+	}
+	return relativePath(coll.Parent) + "/" + coll.ID
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func formatSpecialFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	}
+	return "-Infinity"
+}
+
+func parseSpecialFloat(s string) (float64, error) {
+	switch s {
+	case "NaN":
+		return math.NaN(), nil
+	case "Infinity":
+		return math.Inf(1), nil
+	case "-Infinity":
+		return math.Inf(-1), nil
+	}
+	return 0, errs.Newf(errs.Invalid, "invalid %s value: %s", doubleTag, s)
+}
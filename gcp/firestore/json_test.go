@@ -0,0 +1,150 @@
+package firestore
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/genproto/googleapis/type/latlng"
+
+	"github.com/maxcraig112/go-crud/errs"
+)
+
+// offlineClient returns a client that is never dialled; it is only used to build references.
+func offlineClient(t *testing.T) *FirestoreClient {
+	t.Helper()
+	client, err := NewFirestoreClient(context.Background(), FireStoreClientConfig{DatabaseID: "offline", EmulatorHost: "localhost:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestJSONCodecRoundTrip(t *testing.T) {
+	client := offlineClient(t)
+	ts := time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC)
+
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{"string", "hello"},
+		{"integer", int64(42)},
+		{"large integer", int64(1) << 60},
+		{"fractional double", 1.5},
+		{"whole-number double", 2.0},
+		{"negative whole-number double", -7.0},
+		{"bool", true},
+		{"null", nil},
+		{"timestamp", ts},
+		{"reference", client.GetCollection("users").Doc("abc")},
+		{"nested reference", client.GetCollection("users").Doc("abc").Collection("posts").Doc("xyz")},
+		{"geopoint", &latlng.LatLng{Latitude: -33.8688, Longitude: 151.2093}},
+		{"bytes", []byte{0, 1, 2, 255}},
+		{"vector", firestore.Vector64{1, 2.5, -3}},
+		{"vector32", firestore.Vector32{1, 2.5, -3}},
+		{"array", []interface{}{int64(1), 1.0, "x", ts}},
+		{"nested map", map[string]interface{}{"a": map[string]interface{}{"b": 3.0}}},
+		{"dollar key", map[string]interface{}{"$timestamp": "not a tag"}},
+		{"double dollar key", map[string]interface{}{"$$x": int64(1)}},
+		{"nested dollar keys", map[string]interface{}{"a": map[string]interface{}{"$ref": "users/abc", "$b": []interface{}{map[string]interface{}{"$c": 1.0}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codec := NewJSONCodec(client, JSONOptions{})
+			data, err := json.Marshal(codec.encodeValue(map[string]interface{}{"v": tt.value}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := codec.DecodeData(data)
+			if err != nil {
+				t.Fatalf("DecodeData(%s): %v", data, err)
+			}
+			if !reflect.DeepEqual(got["v"], tt.value) {
+				t.Errorf("round trip through %s = %#v, want %#v", data, got["v"], tt.value)
+			}
+		})
+	}
+}
+
+func TestJSONCodecSpecialFloats(t *testing.T) {
+	codec := NewJSONCodec(nil, JSONOptions{})
+	for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		data, err := json.Marshal(codec.encodeValue(map[string]interface{}{"v": f}))
+		if err != nil {
+			t.Fatalf("marshal %v: %v", f, err)
+		}
+		got, err := codec.DecodeData(data)
+		if err != nil {
+			t.Fatalf("DecodeData(%s): %v", data, err)
+		}
+		g, ok := got["v"].(float64)
+		if !ok || !(g == f || math.IsNaN(g) && math.IsNaN(f)) {
+			t.Errorf("round trip through %s = %#v, want %v", data, got["v"], f)
+		}
+	}
+}
+
+func TestJSONCodecPlain(t *testing.T) {
+	client := offlineClient(t)
+	ts := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	ref := client.GetCollection("users").Doc("abc")
+
+	tests := []struct {
+		name  string
+		opts  JSONOptions
+		value interface{}
+		want  string
+	}{
+		{"timestamp", JSONOptions{Plain: true}, ts, `"2024-05-06T07:08:09Z"`},
+		{"time format", JSONOptions{Plain: true, TimeFormat: time.DateOnly}, ts, `"2024-05-06"`},
+		{"reference path", JSONOptions{Plain: true}, ref, `"users/abc"`},
+		{"reference ID", JSONOptions{Plain: true, Refs: RefID}, ref, `"abc"`},
+		{"whole-number double", JSONOptions{Plain: true}, 2.0, `2`},
+		{"dollar key", JSONOptions{Plain: true}, map[string]interface{}{"$x": int64(1)}, `{"$x":1}`},
+		{"geopoint", JSONOptions{Plain: true}, &latlng.LatLng{Latitude: 1, Longitude: 2}, `{"latitude":1,"longitude":2}`},
+		{"bytes", JSONOptions{Plain: true}, []byte("hi"), `"aGk="`},
+		{"tagged whole-number double", JSONOptions{}, 2.0, `{"$double":2}`},
+		{"tagged dollar key", JSONOptions{}, map[string]interface{}{"$x": int64(1)}, `{"$$x":1}`},
+		{"tagged reference ignores Refs", JSONOptions{Refs: RefID}, ref, `{"$ref":"users/abc"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(NewJSONCodec(client, tt.opts).encodeValue(tt.value))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("encoded = %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONCodecDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"unknown tag", `{"v":{"$nope":1}}`},
+		{"bad timestamp", `{"v":{"$timestamp":"yesterday"}}`},
+		{"odd reference path", `{"v":{"$ref":"users"}}`},
+		{"bad bytes", `{"v":{"$bytes":"***"}}`},
+		{"bad geopoint", `{"v":{"$geopoint":{"latitude":"north"}}}`},
+		{"bad vector", `{"v":{"$vector":[1,"two"]}}`},
+		{"bad double", `{"v":{"$double":"lots"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewJSONCodec(nil, JSONOptions{}).DecodeData([]byte(tt.data))
+			if errs.KindOf(err) != errs.Invalid {
+				t.Errorf("DecodeData(%s) error = %v, want an Invalid error", tt.data, err)
+			}
+		})
+	}
+}
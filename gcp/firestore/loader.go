@@ -0,0 +1,112 @@
+package firestore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// defaultLoaderWait is how long a DocLoader waits to collect further IDs before fetching a batch.
+const defaultLoaderWait = 2 * time.Millisecond
+
+// DocLoader coalesces concurrent GetDoc calls against a single store into batched GetDocs fetches.
+// It is intended to be request-scoped: create one per incoming request so results are cached only
+// for the lifetime of that request.
+type DocLoader struct {
+	ctx   context.Context
+	store *GenericStore
+	wait  time.Duration
+
+	mu      sync.Mutex
+	results map[string]*loaderResult
+	batch   []string
+}
+
+type loaderResult struct {
+	done chan struct{}
+	doc  *firestore.DocumentSnapshot
+	err  error
+}
+
+// NewDocLoader returns a DocLoader for store whose batched fetches run under ctx.
+func NewDocLoader(ctx context.Context, store *GenericStore) *DocLoader {
+	return &DocLoader{
+		ctx:     ctx,
+		store:   store,
+		wait:    defaultLoaderWait,
+		results: make(map[string]*loaderResult),
+	}
+}
+
+// WithWait sets how long the loader collects IDs before dispatching a batch.
+func (l *DocLoader) WithWait(wait time.Duration) *DocLoader {
+	l.wait = wait
+	return l
+}
+
+// GetDoc returns the document with the given ID, batching the fetch with any other GetDoc calls
+// made on the loader around the same time. Returns ErrNotFound if the document does not exist.
+func (l *DocLoader) GetDoc(ctx context.Context, docID string) (*firestore.DocumentSnapshot, error) {
+	l.mu.Lock()
+	result, ok := l.results[docID]
+	if !ok {
+		result = &loaderResult{done: make(chan struct{})}
+		l.results[docID] = result
+		l.batch = append(l.batch, docID)
+
+		switch len(l.batch) {
+		case maxGetAllBatchSize:
+			go l.dispatch(l.takeBatch())
+		case 1:
+			time.AfterFunc(l.wait, func() {
+				l.mu.Lock()
+				batch := l.takeBatch()
+				l.mu.Unlock()
+				l.dispatch(batch)
+			})
+		}
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-result.done:
+		return result.doc, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// takeBatch returns the pending IDs and resets the batch. The caller must hold l.mu.
+func (l *DocLoader) takeBatch() []string {
+	batch := l.batch
+	l.batch = nil
+	return batch
+}
+
+// dispatch fetches a batch of IDs and resolves every waiting GetDoc call.
+func (l *DocLoader) dispatch(batch []string) {
+	if len(batch) == 0 {
+		return
+	}
+
+	docs, _, err := l.store.GetDocs(l.ctx, batch)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, id := range batch {
+		result := l.results[id]
+		switch {
+		case err != nil:
+			result.err = err
+			// don't cache failed fetches so later calls can retry
+			delete(l.results, id)
+		case docs[i] == nil:
+			result.err = ErrNotFound
+		default:
+			result.doc = docs[i]
+		}
+		close(result.done)
+	}
+}
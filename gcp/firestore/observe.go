@@ -0,0 +1,48 @@
+package firestore
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/maxcraig112/go-crud/errs"
+	"github.com/maxcraig112/go-crud/metrics"
+	"github.com/maxcraig112/go-crud/tracing"
+)
+
+// observe starts tracing and metrics instrumentation for a store operation. It returns a context
+// carrying the operation's span and a function that must be called with the operation's result,
+// which returns the result converted to a typed errs error.
+func (s *GenericStore) observe(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, func(error) error) {
+	attrs = append(attrs, tracing.CollectionKey.String(s.collection.Path))
+	ctx, span := tracing.Start(ctx, "firestore", operation, attrs...)
+	start := time.Now()
+	return ctx, func(err error) error {
+		err = errs.From(err)
+		tracing.End(span, err)
+		metrics.ObserveOperation("firestore", operation, collectionPattern(s.collection), start, errs.Code(err).String())
+		return err
+	}
+}
+
+// collectionPattern returns a collection's path relative to its database with the IDs of parent
+// documents replaced by "{id}", e.g. "users/{id}/orders", so subcollections share one metric series.
+func collectionPattern(coll *firestore.CollectionRef) string {
+	segments := strings.Split(CollectionPath(coll), "/")
+	for i := 1; i < len(segments); i += 2 {
+		segments[i] = "{id}"
+	}
+	return strings.Join(segments, "/")
+}
+
+// queryShape describes a query's filters without their values, e.g. "status == ?, createdAt > ?".
+func queryShape(query []QueryParameter) string {
+	parts := make([]string, len(query))
+	for i, q := range query {
+		parts[i] = q.Path + " " + q.Op + " ?"
+	}
+	return strings.Join(parts, ", ")
+}
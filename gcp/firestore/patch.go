@@ -0,0 +1,505 @@
+package firestore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/maxcraig112/go-crud/errs"
+	"github.com/maxcraig112/go-crud/tracing"
+)
+
+// DocValidator checks a patched document before it is written. Returning an error aborts the patch.
+type DocValidator func(data map[string]interface{}) error
+
+// ApplyMergePatch applies an RFC 7396 JSON Merge Patch to the document with the given ID inside a
+// transaction and returns the updated document data. Null values in the patch delete fields.
+// Values may use the tagged forms of JSONCodec, e.g. {"$timestamp": "..."}, to write timestamps,
+// references and other Firestore-native types. If validate is not nil it is run against the patched
+// data before it is written.
+func (s *GenericStore) ApplyMergePatch(ctx context.Context, docID string, patch []byte, validate DocValidator) (map[string]interface{}, error) {
+	var mergePatch interface{}
+	if err := decodeJSON(patch, &mergePatch); err != nil {
+		return nil, err
+	}
+	mergePatch, err := NewJSONCodec(s.client, JSONOptions{}).decodeValue(mergePatch)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.patchDoc(ctx, "ApplyMergePatch", docID, validate, func(data map[string]interface{}) (map[string]interface{}, error) {
+		patched, ok := applyMergePatch(data, deepCopy(mergePatch)).(map[string]interface{})
+		if !ok {
+			return nil, errs.New(errs.Invalid, "merge patch must be a JSON object")
+		}
+		return patched, nil
+	})
+}
+
+// JSONPatchOp is a single RFC 6902 JSON Patch operation.
+type JSONPatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+
+	// hasValue records whether the operation had a "value" member, which may be null
+	hasValue bool
+}
+
+// UnmarshalJSON decodes an operation, recording whether its "value" member was present so a missing
+// value can be told apart from null.
+func (op *JSONPatchOp) UnmarshalJSON(data []byte) error {
+	type plain JSONPatchOp
+	var raw struct {
+		plain
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*op = JSONPatchOp(raw.plain)
+	if raw.Value == nil {
+		return nil
+	}
+	op.hasValue = true
+	return decodeJSON(raw.Value, &op.Value)
+}
+
+// ApplyJSONPatch applies an RFC 6902 JSON Patch to the document with the given ID inside a
+// transaction and returns the updated document data. A failing "test" operation aborts the whole
+// patch with a Conflict error. Values may use the tagged forms of JSONCodec, e.g.
+// {"$timestamp": "..."}, to write timestamps, references and other Firestore-native types. If
+// validate is not nil it is run against the patched data before it is written.
+func (s *GenericStore) ApplyJSONPatch(ctx context.Context, docID string, patch []byte, validate DocValidator) (map[string]interface{}, error) {
+	var ops []JSONPatchOp
+	if err := decodeJSON(patch, &ops); err != nil {
+		return nil, err
+	}
+	codec := NewJSONCodec(s.client, JSONOptions{})
+	for i := range ops {
+		var err error
+		if ops[i].Value, err = codec.decodeValue(ops[i].Value); err != nil {
+			return nil, errs.Wrap(errs.KindOf(err), err, "patch operation "+strconv.Itoa(i)+" has an invalid value")
+		}
+	}
+
+	return s.patchDoc(ctx, "ApplyJSONPatch", docID, validate, func(data map[string]interface{}) (map[string]interface{}, error) {
+		var doc interface{} = data
+		for i, op := range ops {
+			// the transaction may retry, so each attempt works on its own copy of the patch values
+			op.Value = deepCopy(op.Value)
+			var err error
+			if doc, err = applyJSONPatchOp(doc, op); err != nil {
+				return nil, errs.Wrap(errs.KindOf(err), err, "patch operation "+strconv.Itoa(i)+" failed")
+			}
+		}
+		patched, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, errs.New(errs.Invalid, "patched document must be a JSON object")
+		}
+		return patched, nil
+	})
+}
+
+// patchDoc reads, patches, validates and writes a document in a single transaction.
+func (s *GenericStore) patchDoc(ctx context.Context, operation, docID string, validate DocValidator, apply func(map[string]interface{}) (map[string]interface{}, error)) (_ map[string]interface{}, err error) {
+	ctx, done := s.observe(ctx, operation, tracing.DocIDKey.String(docID))
+	defer func() { err = done(err) }()
+
+	docRef := s.collection.Doc(docID)
+	var patched map[string]interface{}
+	err = s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(docRef)
+		if status.Code(err) == codes.NotFound || (err == nil && s.isExpired(snap, time.Now())) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		patched, err = apply(snap.Data())
+		if err != nil {
+			return err
+		}
+		if validate != nil {
+			if err := validate(patched); err != nil {
+				return errs.Wrap(errs.Invalid, err, "patched document failed validation")
+			}
+		}
+		data, err := s.withGeohashes(patched)
+		if err != nil {
+			return err
+		}
+		return tx.Set(docRef, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return patched, nil
+}
+
+// decodeJSON decodes data into v, converting whole numbers to int64 so they are stored as Firestore integers.
+func decodeJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return errs.Wrap(errs.Invalid, err, "invalid JSON document")
+	}
+	normalizeNumbers(reflect.ValueOf(v).Elem())
+	return nil
+}
+
+func normalizeNumbers(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if !v.IsNil() {
+			v.Set(reflect.ValueOf(normalizeValue(v.Interface())))
+		}
+	case reflect.Map:
+		if !v.IsNil() {
+			normalizeValue(v.Interface())
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			normalizeNumbers(v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			normalizeNumbers(v.Field(i))
+		}
+	}
+}
+
+func normalizeValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case json.Number:
+		return jsonNumber(x)
+	case map[string]interface{}:
+		for k, item := range x {
+			x[k] = normalizeValue(item)
+		}
+	case []interface{}:
+		for i, item := range x {
+			x[i] = normalizeValue(item)
+		}
+	}
+	return v
+}
+
+func jsonNumber(n json.Number) interface{} {
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	f, _ := n.Float64()
+	return f
+}
+
+// applyMergePatch implements the RFC 7396 MergePatch algorithm.
+func applyMergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{})
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = applyMergePatch(targetObj[key], value)
+	}
+	return targetObj
+}
+
+func applyJSONPatchOp(doc interface{}, op JSONPatchOp) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if !op.hasValue {
+			return nil, errs.Newf(errs.Invalid, "%q operation is missing a value", op.Op)
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		return addValue(doc, path, op.Value)
+	case "remove":
+		doc, _, err := removeValue(doc, path)
+		return doc, err
+	case "replace":
+		if _, err := getValue(doc, path); err != nil {
+			return nil, err
+		}
+		if doc, _, err = removeValue(doc, path); err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, op.Value)
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		var value interface{}
+		if op.Op == "move" {
+			if isPrefix(from, path) && len(from) < len(path) {
+				return nil, errs.Newf(errs.Invalid, "cannot move %q into one of its children", op.From)
+			}
+			doc, value, err = removeValue(doc, from)
+		} else {
+			value, err = getValue(doc, from)
+			value = deepCopy(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, value)
+	case "test":
+		value, err := getValue(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !patchValuesEqual(value, op.Value) {
+			return nil, errs.Newf(errs.Conflict, "test failed for path %q", op.Path)
+		}
+		return doc, nil
+	}
+	return nil, errs.Newf(errs.Invalid, "unsupported patch operation %q", op.Op)
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errs.Newf(errs.Invalid, "invalid JSON pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+	i, err := strconv.Atoi(token)
+	max := length - 1
+	if allowEnd {
+		max = length
+	}
+	if err != nil || i < 0 || i > max || (len(token) > 1 && token[0] == '0') {
+		return 0, errs.Newf(errs.Invalid, "invalid array index %q", token)
+	}
+	return i, nil
+}
+
+func getValue(doc interface{}, path []string) (interface{}, error) {
+	current := doc
+	for _, token := range path {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, errs.Newf(errs.Invalid, "path %q does not exist", "/"+strings.Join(path, "/"))
+			}
+			current = value
+		case []interface{}:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			current = node[i]
+		default:
+			return nil, errs.Newf(errs.Invalid, "path %q does not exist", "/"+strings.Join(path, "/"))
+		}
+	}
+	return current, nil
+}
+
+// addValue implements the "add" operation, returning the (possibly replaced) document.
+func addValue(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := getValue(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+		return doc, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(node), true)
+		if err != nil {
+			return nil, err
+		}
+		node = append(node, nil)
+		copy(node[i+1:], node[i:])
+		node[i] = value
+		return setParent(doc, path[:len(path)-1], node)
+	}
+	return nil, errs.Newf(errs.Invalid, "cannot add to path %q", "/"+strings.Join(path, "/"))
+}
+
+// removeValue removes the value at path, returning the document and the removed value.
+func removeValue(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+	parent, err := getValue(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+	last := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		value, ok := node[last]
+		if !ok {
+			return nil, nil, errs.Newf(errs.Invalid, "path %q does not exist", "/"+strings.Join(path, "/"))
+		}
+		delete(node, last)
+		return doc, value, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		value := node[i]
+		node = append(node[:i:i], node[i+1:]...)
+		doc, err = setParent(doc, path[:len(path)-1], node)
+		return doc, value, err
+	}
+	return nil, nil, errs.Newf(errs.Invalid, "cannot remove path %q", "/"+strings.Join(path, "/"))
+}
+
+// setParent stores a resized array back at path, since appending may reallocate it.
+func setParent(doc interface{}, path []string, array []interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return array, nil
+	}
+	grandparent, err := getValue(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+	switch node := grandparent.(type) {
+	case map[string]interface{}:
+		node[last] = array
+	case []interface{}:
+		i, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = array
+	}
+	return doc, nil
+}
+
+// patchValuesEqual compares a stored value with a JSON patch value. Numbers are compared by value, so a
+// stored double 1.0 equals the JSON integer 1, and timestamps equal the RFC 3339 strings they format as.
+func patchValuesEqual(stored, value interface{}) bool {
+	if a, ok := stored.(int64); ok {
+		if b, ok := value.(int64); ok {
+			return a == b
+		}
+	}
+	if a, ok := toFloat(stored); ok {
+		b, ok := toFloat(value)
+		return ok && a == b
+	}
+	if ref, ok := stored.(*firestore.DocumentRef); ok {
+		other, ok := value.(*firestore.DocumentRef)
+		return ok && (ref == nil) == (other == nil) && (ref == nil || ref.Path == other.Path)
+	}
+	if t, ok := stored.(time.Time); ok {
+		switch v := value.(type) {
+		case time.Time:
+			return t.Equal(v)
+		case string:
+			parsed, err := time.Parse(time.RFC3339Nano, v)
+			return err == nil && t.Equal(parsed)
+		}
+		return false
+	}
+
+	switch a := stored.(type) {
+	case map[string]interface{}:
+		b, ok := value.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, item := range a {
+			other, ok := b[k]
+			if !ok || !patchValuesEqual(item, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := value.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !patchValuesEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(stored, value)
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = deepCopy(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = deepCopy(item)
+		}
+		return out
+	}
+	return value
+}
@@ -0,0 +1,132 @@
+package firestore
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/maxcraig112/go-crud/errs"
+)
+
+func mustDecodeJSON(t *testing.T, data string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := decodeJSON([]byte(data), &v); err != nil {
+		t.Fatalf("decodeJSON(%s): %v", data, err)
+	}
+	return v
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	// cases from RFC 7396 appendix A
+	tests := []struct {
+		target, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target+" "+tt.patch, func(t *testing.T) {
+			got := applyMergePatch(mustDecodeJSON(t, tt.target), mustDecodeJSON(t, tt.patch))
+			if want := mustDecodeJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("applyMergePatch() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApplyJSONPatchOps(t *testing.T) {
+	tests := []struct {
+		name     string
+		doc      string
+		patch    string
+		want     string
+		wantKind errs.Kind
+	}{
+		{name: "add field", doc: `{"foo":"bar"}`, patch: `[{"op":"add","path":"/baz","value":"qux"}]`, want: `{"foo":"bar","baz":"qux"}`},
+		{name: "add array element", doc: `{"foo":["bar","baz"]}`, patch: `[{"op":"add","path":"/foo/1","value":"qux"}]`, want: `{"foo":["bar","qux","baz"]}`},
+		{name: "append to array", doc: `{"foo":[1]}`, patch: `[{"op":"add","path":"/foo/-","value":2}]`, want: `{"foo":[1,2]}`},
+		{name: "add null value", doc: `{}`, patch: `[{"op":"add","path":"/x","value":null}]`, want: `{"x":null}`},
+		{name: "remove field", doc: `{"baz":"qux","foo":"bar"}`, patch: `[{"op":"remove","path":"/baz"}]`, want: `{"foo":"bar"}`},
+		{name: "remove array element", doc: `{"foo":["bar","qux","baz"]}`, patch: `[{"op":"remove","path":"/foo/1"}]`, want: `{"foo":["bar","baz"]}`},
+		{name: "replace", doc: `{"baz":"qux"}`, patch: `[{"op":"replace","path":"/baz","value":"boo"}]`, want: `{"baz":"boo"}`},
+		{name: "move", doc: `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, patch: `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, want: `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{name: "copy is independent", doc: `{"a":{"b":1}}`, patch: `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, want: `{"a":{"b":1},"c":{"b":2}}`},
+		{name: "escaped pointer", doc: `{"a/b":1,"m~n":2}`, patch: `[{"op":"remove","path":"/a~1b"},{"op":"remove","path":"/m~0n"}]`, want: `{}`},
+		{name: "test passes", doc: `{"baz":"qux","foo":["a",2,"c"]}`, patch: `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, want: `{"baz":"qux","foo":["a",2,"c"]}`},
+		{name: "test fails", doc: `{"baz":"qux"}`, patch: `[{"op":"test","path":"/baz","value":"bar"}]`, wantKind: errs.Conflict},
+		{name: "test compares numbers by value", doc: `{"n":1.0}`, patch: `[{"op":"test","path":"/n","value":1}]`, want: `{"n":1.0}`},
+		{name: "add without value", doc: `{}`, patch: `[{"op":"add","path":"/x"}]`, wantKind: errs.Invalid},
+		{name: "replace without value", doc: `{"x":1}`, patch: `[{"op":"replace","path":"/x"}]`, wantKind: errs.Invalid},
+		{name: "test without value", doc: `{"x":null}`, patch: `[{"op":"test","path":"/x"}]`, wantKind: errs.Invalid},
+		{name: "remove missing path", doc: `{}`, patch: `[{"op":"remove","path":"/x"}]`, wantKind: errs.Invalid},
+		{name: "move into own child", doc: `{"a":{"b":{}}}`, patch: `[{"op":"move","from":"/a","path":"/a/b/c"}]`, wantKind: errs.Invalid},
+		{name: "array index with leading zero", doc: `{"a":[1,2]}`, patch: `[{"op":"remove","path":"/a/01"}]`, wantKind: errs.Invalid},
+		{name: "unknown operation", doc: `{}`, patch: `[{"op":"frobnicate","path":"/x"}]`, wantKind: errs.Invalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ops []JSONPatchOp
+			if err := decodeJSON([]byte(tt.patch), &ops); err != nil {
+				t.Fatal(err)
+			}
+			doc := mustDecodeJSON(t, tt.doc)
+			var err error
+			for _, op := range ops {
+				if doc, err = applyJSONPatchOp(doc, op); err != nil {
+					break
+				}
+			}
+			if tt.wantKind != "" {
+				if errs.KindOf(err) != tt.wantKind {
+					t.Fatalf("error = %v, want kind %s", err, tt.wantKind)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := mustDecodeJSON(t, tt.want); !reflect.DeepEqual(doc, want) {
+				t.Errorf("patched = %v, want %v", doc, want)
+			}
+		})
+	}
+}
+
+func TestPatchValuesEqual(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name          string
+		stored, value interface{}
+		want          bool
+	}{
+		{"double and integer", 1.0, int64(1), true},
+		{"integers", int64(3), int64(3), true},
+		{"different numbers", 1.5, int64(1), false},
+		{"number and string", int64(1), "1", false},
+		{"timestamp and RFC 3339 string", ts, "2024-01-02T03:04:05Z", true},
+		{"timestamp and offset string", ts, "2024-01-02T04:04:05+01:00", true},
+		{"timestamp and other string", ts, "2024-01-02", false},
+		{"timestamps", ts, ts.In(time.FixedZone("X", 7200)), true},
+		{"nested maps", map[string]interface{}{"a": []interface{}{1.0}}, map[string]interface{}{"a": []interface{}{int64(1)}}, true},
+		{"map with extra key", map[string]interface{}{"a": 1.0}, map[string]interface{}{"a": 1.0, "b": nil}, false},
+		{"arrays of different length", []interface{}{int64(1)}, []interface{}{int64(1), int64(2)}, false},
+		{"nulls", nil, nil, true},
+		{"strings", "a", "a", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := patchValuesEqual(tt.stored, tt.value); got != tt.want {
+				t.Errorf("patchValuesEqual(%v, %v) = %v, want %v", tt.stored, tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package firestore
+
+import (
+	"context"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+
+	"github.com/maxcraig112/go-crud/errs"
+)
+
+// PopulatedDoc is a document whose relation fields have been replaced with the referenced documents.
+// A populated field holds a *PopulatedDoc, or a []*PopulatedDoc when the field holds several references.
+type PopulatedDoc struct {
+	ID   string                 `json:"id"`
+	Ref  *firestore.DocumentRef `json:"-"`
+	Data map[string]interface{} `json:"data"`
+}
+
+// WithRelation declares that the top level field on this store's documents references documents in target.
+// The field may hold a document ID, a *firestore.DocumentRef, or a slice of either. References must
+// point into target's collection.
+func (s *GenericStore) WithRelation(field string, target *GenericStore) *GenericStore {
+	if s.relations == nil {
+		s.relations = make(map[string]*GenericStore)
+	}
+	s.relations[field] = target
+	return s
+}
+
+// GetDocPopulated returns a single document with the given relation paths populated.
+func (s *GenericStore) GetDocPopulated(ctx context.Context, docID string, populate ...string) (*PopulatedDoc, error) {
+	doc, err := s.GetDoc(ctx, docID)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := s.Populate(ctx, []*firestore.DocumentSnapshot{doc}, populate...)
+	if err != nil {
+		return nil, err
+	}
+	return docs[0], nil
+}
+
+// ReadCollectionPopulated returns every document matching the query with the given relation paths populated.
+func (s *GenericStore) ReadCollectionPopulated(ctx context.Context, query []QueryParameter, populate ...string) ([]*PopulatedDoc, error) {
+	docs, err := s.ReadCollection(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return s.Populate(ctx, docs, populate...)
+}
+
+// Populate embeds the documents referenced by the given relation paths into docs. Each path names a
+// relation declared with WithRelation, and nested relations are separated by dots, e.g. "author.company".
+// Referenced documents are fetched with one batched request per relation per level, regardless of how
+// many documents reference them. References to missing documents are populated as nil.
+func (s *GenericStore) Populate(ctx context.Context, docs []*firestore.DocumentSnapshot, populate ...string) ([]*PopulatedDoc, error) {
+	populated := make([]*PopulatedDoc, len(docs))
+	for i, doc := range docs {
+		populated[i] = newPopulatedDoc(doc)
+	}
+
+	if err := s.populateLevel(ctx, populated, buildPopulateTree(populate)); err != nil {
+		return nil, err
+	}
+	return populated, nil
+}
+
+// populateTree maps a relation field to the relations nested beneath it.
+type populateTree map[string]populateTree
+
+func buildPopulateTree(paths []string) populateTree {
+	tree := populateTree{}
+	for _, path := range paths {
+		node := tree
+		for _, field := range strings.Split(path, ".") {
+			if node[field] == nil {
+				node[field] = populateTree{}
+			}
+			node = node[field]
+		}
+	}
+	return tree
+}
+
+func newPopulatedDoc(doc *firestore.DocumentSnapshot) *PopulatedDoc {
+	return &PopulatedDoc{
+		ID:   doc.Ref.ID,
+		Ref:  doc.Ref,
+		Data: doc.Data(),
+	}
+}
+
+func (s *GenericStore) populateLevel(ctx context.Context, docs []*PopulatedDoc, tree populateTree) error {
+	for field, subtree := range tree {
+		target, ok := s.relations[field]
+		if !ok {
+			return errs.Newf(errs.Invalid, "no relation declared for field %q", field)
+		}
+
+		// collect the unique referenced IDs across every document
+		var ids []string
+		seen := make(map[string]bool)
+		for _, doc := range docs {
+			refIDs, err := referencedIDs(doc.Data[field], target.collection)
+			if err != nil {
+				return errs.Wrap(errs.Invalid, err, "invalid relation field "+field+" in "+relativePath(doc.Ref))
+			}
+			for _, id := range refIDs {
+				if !seen[id] {
+					seen[id] = true
+					ids = append(ids, id)
+				}
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		snaps, _, err := target.GetDocs(ctx, ids)
+		if err != nil {
+			return err
+		}
+
+		byID := make(map[string]*PopulatedDoc, len(snaps))
+		var fetched []*PopulatedDoc
+		for i, snap := range snaps {
+			if snap == nil {
+				continue
+			}
+			doc := newPopulatedDoc(snap)
+			byID[ids[i]] = doc
+			fetched = append(fetched, doc)
+		}
+
+		if err := target.populateLevel(ctx, fetched, subtree); err != nil {
+			return err
+		}
+
+		for _, doc := range docs {
+			switch value := doc.Data[field].(type) {
+			case []interface{}:
+				refIDs, _ := referencedIDs(value, target.collection)
+				related := make([]*PopulatedDoc, 0, len(refIDs))
+				for _, id := range refIDs {
+					related = append(related, byID[id])
+				}
+				doc.Data[field] = related
+			case nil:
+			default:
+				if refIDs, _ := referencedIDs(value, target.collection); len(refIDs) == 1 {
+					doc.Data[field] = byID[refIDs[0]]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// referencedIDs extracts document IDs from a field holding an ID, a *firestore.DocumentRef, or a slice of either.
+// References to documents outside coll are rejected rather than resolved by ID in the wrong collection.
+func referencedIDs(value interface{}, coll *firestore.CollectionRef) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return nil, nil
+		}
+		return []string{v}, nil
+	case *firestore.DocumentRef:
+		if v == nil {
+			return nil, nil
+		}
+		if v.Parent == nil || v.Parent.Path != coll.Path {
+			return nil, errs.Newf(errs.Invalid, "reference %s is not in collection %s", relativePath(v), CollectionPath(coll))
+		}
+		return []string{v.ID}, nil
+	case []interface{}:
+		var ids []string
+		for _, item := range v {
+			itemIDs, err := referencedIDs(item, coll)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, itemIDs...)
+		}
+		return ids, nil
+	}
+	return nil, nil
+}
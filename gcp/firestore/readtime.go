@@ -0,0 +1,129 @@
+package firestore
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+type readTimeKey struct{}
+
+// maxReadTimeBatchSize is the number of documents fetched per round trip by GetDocs when reading at a
+// fixed time, which is limited by the size of an "in" filter.
+const maxReadTimeBatchSize = 30
+
+// WithReadTime returns a context that makes the store's read methods (GetDoc, GetDocs,
+// ReadCollection, GetAggregationWithQuery, FindNearest) return data as it was at readTime.
+// Firestore reads at whole-second precision, so readTime is truncated to the second. Read times
+// must be within the last hour, or a whole minute within the database's point-in-time recovery
+// window.
+func WithReadTime(ctx context.Context, readTime time.Time) context.Context {
+	return context.WithValue(ctx, readTimeKey{}, readTime.Truncate(time.Second))
+}
+
+// ReadTimeFrom returns the read time set on ctx with WithReadTime.
+func ReadTimeFrom(ctx context.Context) (time.Time, bool) {
+	readTime, ok := ctx.Value(readTimeKey{}).(time.Time)
+	return readTime, ok
+}
+
+// Snapshot pins reads across any number of stores to the same point in time, so reports spanning
+// several collections see a consistent view of the database.
+//
+//	snap := firestore.NewSnapshot(time.Now())
+//	ctx = snap.Context(ctx)
+//	orders, err := orderStore.ReadCollection(ctx, query)
+//	customers, _, err := customerStore.GetDocs(ctx, customerIDs)
+type Snapshot struct {
+	readTime time.Time
+}
+
+// NewSnapshot returns a snapshot that reads at readTime, truncated to the second.
+func NewSnapshot(readTime time.Time) *Snapshot {
+	return &Snapshot{readTime: readTime.Truncate(time.Second)}
+}
+
+// ReadTime returns the time the snapshot reads at.
+func (s *Snapshot) ReadTime() time.Time { return s.readTime }
+
+// Context returns a context that pins store reads to the snapshot's read time.
+func (s *Snapshot) Context(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readTimeKey{}, s.readTime)
+}
+
+// queryCollection returns the collection reads should query, pinned to the context's read time if
+// it has one. A new CollectionRef is created for pinned reads because queries share their read
+// options with the collection they were built from.
+func (s *GenericStore) queryCollection(ctx context.Context) *firestore.CollectionRef {
+	readTime, ok := ReadTimeFrom(ctx)
+	if !ok {
+		return s.collection
+	}
+	var coll *firestore.CollectionRef
+	if s.collection.Parent != nil {
+		coll = s.collection.Parent.Collection(s.collection.ID)
+	} else {
+		coll = s.client.GetCollection(s.collection.ID)
+	}
+	return coll.WithReadOptions(firestore.ReadTime(readTime))
+}
+
+// docRef returns a reference to the document with the given ID, pinned to the context's read time
+// if it has one.
+func (s *GenericStore) docRef(ctx context.Context, docID string) *firestore.DocumentRef {
+	ref := s.collection.Doc(docID)
+	if readTime, ok := ReadTimeFrom(ctx); ok {
+		ref.WithReadOptions(firestore.ReadTime(readTime))
+	}
+	return ref
+}
+
+// getAllAt fetches refs as they were at the context's read time. The client's batched get does not
+// accept per-call read options, so the documents are fetched with a document ID query instead.
+// Snapshots are returned in the order of refs, with a missing snapshot for documents that did not
+// exist.
+func (s *GenericStore) getAllAt(ctx context.Context, refs []*firestore.DocumentRef) ([]*firestore.DocumentSnapshot, error) {
+	coll := s.queryCollection(ctx)
+	found := make(map[string]*firestore.DocumentSnapshot, len(refs))
+
+	for start := 0; start < len(refs); start += maxReadTimeBatchSize {
+		end := min(start+maxReadTimeBatchSize, len(refs))
+		iter := coll.Where(firestore.DocumentID, "in", refs[start:end]).Documents(ctx)
+		docs, err := iter.GetAll()
+		iter.Stop()
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			found[doc.Ref.ID] = doc
+		}
+	}
+
+	snaps := make([]*firestore.DocumentSnapshot, len(refs))
+	for i, ref := range refs {
+		if doc, ok := found[ref.ID]; ok {
+			snaps[i] = doc
+		} else {
+			snaps[i] = &firestore.DocumentSnapshot{Ref: ref}
+		}
+	}
+	return snaps, nil
+}
+
+// countDocs counts the documents matching q that have not expired. Aggregation queries neither
+// support read times nor skip expired documents, so these counts are computed from a query reading
+// only the expiry field, or keys only, instead, which is billed per document.
+func (s *GenericStore) countDocs(ctx context.Context, q firestore.Query) (int64, error) {
+	var fields []string
+	if s.ttl != nil && s.ttl.ExpiryField != "" {
+		fields = append(fields, s.ttl.ExpiryField)
+	}
+	iter := q.Select(fields...).Documents(ctx)
+	defer iter.Stop()
+	docs, err := iter.GetAll()
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(s.filterExpired(ctx, docs))), nil
+}
@@ -0,0 +1,45 @@
+package firestore
+
+import (
+	"context"
+	"reflect"
+
+	"cloud.google.com/go/firestore"
+
+	"github.com/maxcraig112/go-crud/retry"
+)
+
+// WithRetryPolicy sets the policy used to retry store operations that fail with transient errors.
+// By default operations are attempted once.
+func (s *GenericStore) WithRetryPolicy(policy retry.Policy) *GenericStore {
+	s.retryPolicy = policy
+	return s
+}
+
+// withRetry runs fn under the store's retry policy.
+func (s *GenericStore) withRetry(ctx context.Context, operation string, idempotent bool, fn func(ctx context.Context) error) error {
+	return s.retryPolicy.Do(ctx, "firestore", operation, idempotent, fn)
+}
+
+// firestorePkgPath identifies the unexported transform types behind firestore.Increment,
+// firestore.ArrayUnion and friends.
+var firestorePkgPath = reflect.TypeOf(firestore.Update{}).PkgPath()
+
+// idempotentTransforms are the field transforms that leave the same result when applied twice.
+var idempotentTransforms = map[reflect.Type]bool{
+	reflect.TypeOf(firestore.ArrayUnion()):  true,
+	reflect.TypeOf(firestore.ArrayRemove()): true,
+}
+
+// isIdempotentUpdate reports whether applying updates twice has the same effect as applying them once.
+// Numeric transforms such as Increment are not idempotent; plain values, Delete, ServerTimestamp,
+// ArrayUnion and ArrayRemove are.
+func isIdempotentUpdate(updates []firestore.Update) bool {
+	for _, u := range updates {
+		t := reflect.TypeOf(u.Value)
+		if t != nil && t.PkgPath() == firestorePkgPath && t.Kind() == reflect.Struct && !idempotentTransforms[t] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+package firestore
+
+import (
+	"context"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/maxcraig112/go-crud/errs"
+	"github.com/maxcraig112/go-crud/tracing"
+)
+
+// defaultScanPageSize is the number of documents fetched per page when scanning a collection.
+const defaultScanPageSize = 500
+
+// ScanOptions controls how Scan walks a collection.
+type ScanOptions struct {
+	// Query restricts the scan to matching documents.
+	Query []QueryParameter
+	// PageSize is the number of documents fetched per round trip. Defaults to 500.
+	PageSize int
+	// StartAfter resumes a scan after the document with this ID. When Query has inequality filters
+	// the document must still exist, since the scan resumes from its field values.
+	StartAfter string
+	// Limit stops the scan after this many documents have been passed to fn. Zero means no limit.
+	Limit int
+}
+
+// Scan streams the documents matching opts.Query to fn a page at a time, so collections of any size
+// can be processed without holding them in memory. Documents are ordered by the query's inequality
+// fields and then by document ID, the ordering Firestore serves without an extra composite index.
+// Each page is fetched with the store's retry policy. Expired documents are skipped on stores with a
+// TTL. Returning an error from fn stops the scan and returns that error.
+func (s *GenericStore) Scan(ctx context.Context, opts ScanOptions, fn func(*firestore.DocumentSnapshot) error) (err error) {
+	ctx, done := s.observe(ctx, "Scan", tracing.QueryKey.String(queryShape(opts.Query)))
+	defer func() { err = done(err) }()
+
+	return s.scan(ctx, opts, false, fn)
+}
+
+// scan implements Scan, passing expired documents to fn as well when includeExpired is set.
+func (s *GenericStore) scan(ctx context.Context, opts ScanOptions, includeExpired bool, fn func(*firestore.DocumentSnapshot) error) (err error) {
+	s.trackQuery(opts.Query, scanOrder(opts.Query)...)
+
+	pageSize := opts.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultScanPageSize
+	}
+
+	base := s.buildQuery(ctx, opts.Query)
+	order := scanOrder(opts.Query)
+	for _, o := range order {
+		base = base.OrderBy(o.Path, o.Direction)
+	}
+	base = base.OrderBy(firestore.DocumentID, firestore.Asc)
+
+	var after *firestore.DocumentSnapshot
+	if opts.StartAfter != "" && len(order) > 0 {
+		err = s.withRetry(ctx, "Scan", true, func(ctx context.Context) error {
+			after, err = s.docRef(ctx, opts.StartAfter).Get(ctx)
+			return err
+		})
+		if status.Code(err) == codes.NotFound {
+			return errs.Newf(errs.Invalid, "cannot resume scan after deleted document %q", opts.StartAfter)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	delivered := 0
+	for {
+		limit := pageSize
+		if opts.Limit > 0 {
+			limit = min(limit, opts.Limit-delivered)
+		}
+		if limit <= 0 {
+			return nil
+		}
+
+		q := base.Limit(limit)
+		switch {
+		case after != nil:
+			q = q.StartAfter(after)
+		case opts.StartAfter != "":
+			q = q.StartAfter(opts.StartAfter)
+		}
+
+		var page []*firestore.DocumentSnapshot
+		err = s.withRetry(ctx, "Scan", true, func(ctx context.Context) error {
+			page, _, err = s.getAll(ctx, q, nil)
+			return err
+		})
+		if err != nil {
+			return s.indexError(opts.Query, err)
+		}
+
+		now := s.expiryTime(ctx)
+		for _, doc := range page {
+			if !includeExpired && s.isExpired(doc, now) {
+				continue
+			}
+			if err := fn(doc); err != nil {
+				return err
+			}
+			delivered++
+		}
+		if len(page) < limit {
+			return nil
+		}
+		after = page[len(page)-1]
+	}
+}
+
+// scanOrder returns the orderings Scan applies before the document ID: each inequality field of
+// query, ascending, in the order they first appear.
+func scanOrder(query []QueryParameter) []OrderBy {
+	var order []OrderBy
+	ordered := make(map[string]bool)
+	for _, q := range query {
+		if isInequality(q.Op) && !ordered[q.Path] {
+			order = append(order, OrderBy{Path: q.Path, Direction: firestore.Asc})
+			ordered[q.Path] = true
+		}
+	}
+	return order
+}
+
+// isInequality reports whether a query operator is a range or inequality filter, which Firestore
+// requires the query to be ordered by.
+func isInequality(op string) bool {
+	switch op {
+	case "==", "in", "array-contains", "array-contains-any":
+		return false
+	}
+	return true
+}
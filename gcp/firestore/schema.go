@@ -0,0 +1,431 @@
+package firestore
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/genproto/googleapis/type/latlng"
+
+	"github.com/maxcraig112/go-crud/errs"
+)
+
+// FieldType is the Firestore type of a stored value.
+type FieldType string
+
+const (
+	TypeNull      FieldType = "null"
+	TypeBoolean   FieldType = "boolean"
+	TypeInteger   FieldType = "integer"
+	TypeDouble    FieldType = "double"
+	TypeString    FieldType = "string"
+	TypeBytes     FieldType = "bytes"
+	TypeTimestamp FieldType = "timestamp"
+	TypeReference FieldType = "reference"
+	TypeGeoPoint  FieldType = "geopoint"
+	TypeVector    FieldType = "vector"
+	TypeArray     FieldType = "array"
+	TypeMap       FieldType = "map"
+)
+
+// defaultSampleSize is the number of example document IDs kept per schema finding.
+const defaultSampleSize = 5
+
+var (
+	typeOfBytes    = reflect.TypeOf([]byte(nil))
+	typeOfVector64 = reflect.TypeOf(firestore.Vector64(nil))
+	typeOfVector32 = reflect.TypeOf(firestore.Vector32(nil))
+)
+
+// fieldTypeOf returns the Firestore type of a value read from a document snapshot.
+func fieldTypeOf(v interface{}) FieldType {
+	switch v.(type) {
+	case nil:
+		return TypeNull
+	case bool:
+		return TypeBoolean
+	case int64:
+		return TypeInteger
+	case float64:
+		return TypeDouble
+	case string:
+		return TypeString
+	case []byte:
+		return TypeBytes
+	case time.Time:
+		return TypeTimestamp
+	case *firestore.DocumentRef:
+		return TypeReference
+	case *latlng.LatLng:
+		return TypeGeoPoint
+	case firestore.Vector64, firestore.Vector32:
+		return TypeVector
+	case []interface{}:
+		return TypeArray
+	case map[string]interface{}:
+		return TypeMap
+	}
+	return FieldType(reflect.TypeOf(v).String())
+}
+
+// walkFields calls fn for every field of data, descending into maps. Nested fields are named by
+// their dotted path.
+func walkFields(prefix string, data map[string]interface{}, fn func(path string, value interface{})) {
+	for key, value := range data {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		fn(path, value)
+		if m, ok := value.(map[string]interface{}); ok {
+			walkFields(path, m, fn)
+		}
+	}
+}
+
+// appendSample adds id to samples unless it already holds n IDs.
+func appendSample(samples []string, id string, n int) []string {
+	if len(samples) >= n {
+		return samples
+	}
+	return append(samples, id)
+}
+
+// SchemaScanOptions controls InferSchema and ValidateSchema.
+type SchemaScanOptions struct {
+	ScanOptions
+	// SampleSize is the number of example document IDs kept per finding. Defaults to 5.
+	SampleSize int
+}
+
+func (o SchemaScanOptions) sampleSize() int {
+	if o.SampleSize <= 0 {
+		return defaultSampleSize
+	}
+	return o.SampleSize
+}
+
+// FieldStats describes how a field appears across the scanned documents.
+type FieldStats struct {
+	Path string
+	// Count is the number of documents containing the field.
+	Count int
+	// Frequency is the fraction of scanned documents containing the field.
+	Frequency float64
+	// Types counts the documents storing the field as each type.
+	Types map[FieldType]int
+	// SampleIDs holds example IDs of documents storing the field as each type.
+	SampleIDs map[FieldType][]string
+}
+
+// Schema is the field layout inferred from a collection's documents.
+type Schema struct {
+	Collection string
+	Documents  int
+	// Fields are sorted by path.
+	Fields []FieldStats
+}
+
+// InferSchema scans the collection and reports every field path found, how often it occurs and
+// which types it is stored as. Documents are streamed with Scan, so memory use depends on the
+// number of distinct fields rather than the size of the collection.
+func (s *GenericStore) InferSchema(ctx context.Context, opts SchemaScanOptions) (*Schema, error) {
+	samples := opts.sampleSize()
+	schema := &Schema{Collection: s.collection.Path}
+	fields := make(map[string]*FieldStats)
+
+	err := s.Scan(ctx, opts.ScanOptions, func(doc *firestore.DocumentSnapshot) error {
+		schema.Documents++
+		walkFields("", doc.Data(), func(path string, value interface{}) {
+			stats, ok := fields[path]
+			if !ok {
+				stats = &FieldStats{Path: path, Types: make(map[FieldType]int), SampleIDs: make(map[FieldType][]string)}
+				fields[path] = stats
+			}
+			typ := fieldTypeOf(value)
+			stats.Count++
+			stats.Types[typ]++
+			stats.SampleIDs[typ] = appendSample(stats.SampleIDs[typ], doc.Ref.ID, samples)
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stats := range fields {
+		stats.Frequency = float64(stats.Count) / float64(schema.Documents)
+		schema.Fields = append(schema.Fields, *stats)
+	}
+	sort.Slice(schema.Fields, func(i, j int) bool { return schema.Fields[i].Path < schema.Fields[j].Path })
+	return schema, nil
+}
+
+// SchemaField declares a field expected in a collection's documents.
+type SchemaField struct {
+	// Path is the dotted path of the field, e.g. "address.city".
+	Path string
+	// Types lists the types the field may be stored as. Empty means any type.
+	Types []FieldType
+	// Required reports the field as missing from documents that do not contain it. Nested fields
+	// are only required when their parent map is present.
+	Required bool
+	// Open allows the field to hold a map with any keys, which are not reported as extra fields.
+	Open bool
+}
+
+// SchemaDefinition declares the fields expected in a collection's documents.
+type SchemaDefinition struct {
+	Fields []SchemaField
+	// AllowExtra stops fields that are not declared from being reported.
+	AllowExtra bool
+}
+
+// SchemaFromStruct declares a schema matching the documents Firestore stores for model, a struct or
+// pointer to a struct. Fields without omitempty are required; pointer, slice, map and interface
+// fields may also be null. Nested structs are declared field by field, while maps and interfaces
+// accept any keys.
+func SchemaFromStruct(model interface{}) (SchemaDefinition, error) {
+	t := reflect.TypeOf(model)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return SchemaDefinition{}, errs.Newf(errs.Invalid, "schema model must be a struct, got %T", model)
+	}
+	var def SchemaDefinition
+	declareStruct(&def, "", t)
+	return def, nil
+}
+
+func declareStruct(def *SchemaDefinition, prefix string, t reflect.Type) {
+	for _, f := range structFields(t) {
+		path := f.name
+		if prefix != "" {
+			path = prefix + "." + f.name
+		}
+		field := SchemaField{Path: path, Required: !f.omitEmpty}
+
+		ft := f.typ
+		nullable := false
+		for ft.Kind() == reflect.Ptr && !isLeafType(ft) {
+			ft, nullable = ft.Elem(), true
+		}
+		if isLeafType(ft) && ft.Kind() == reflect.Ptr {
+			nullable = true
+		}
+
+		switch {
+		case ft == typeOfTime:
+			field.Types = []FieldType{TypeTimestamp}
+		case ft == typeOfLatLng:
+			field.Types = []FieldType{TypeGeoPoint}
+		case ft == typeOfDocumentRef:
+			field.Types = []FieldType{TypeReference}
+		case ft == typeOfBytes:
+			field.Types, nullable = []FieldType{TypeBytes}, true
+		case ft == typeOfVector64 || ft == typeOfVector32:
+			field.Types, nullable = []FieldType{TypeVector}, true
+		case ft.Kind() == reflect.Interface:
+			field.Open = true
+		case ft.Kind() == reflect.Bool:
+			field.Types = []FieldType{TypeBoolean}
+		case ft.Kind() >= reflect.Int && ft.Kind() <= reflect.Uint64:
+			field.Types = []FieldType{TypeInteger}
+		case ft.Kind() == reflect.Float32 || ft.Kind() == reflect.Float64:
+			field.Types = []FieldType{TypeDouble}
+		case ft.Kind() == reflect.String:
+			field.Types = []FieldType{TypeString}
+		case ft.Kind() == reflect.Slice || ft.Kind() == reflect.Array:
+			field.Types = []FieldType{TypeArray}
+			nullable = nullable || ft.Kind() == reflect.Slice
+		case ft.Kind() == reflect.Map:
+			field.Types, field.Open = []FieldType{TypeMap}, true
+			nullable = true
+		case ft.Kind() == reflect.Struct:
+			field.Types = []FieldType{TypeMap}
+		}
+		if nullable && len(field.Types) > 0 {
+			field.Types = append(field.Types, TypeNull)
+		}
+
+		def.Fields = append(def.Fields, field)
+		if ft.Kind() == reflect.Struct && !isLeafType(ft) {
+			declareStruct(def, path, ft)
+		}
+	}
+}
+
+// SchemaIssueKind identifies a kind of schema violation.
+type SchemaIssueKind string
+
+const (
+	MissingField SchemaIssueKind = "missing"
+	ExtraField   SchemaIssueKind = "extra"
+	WrongType    SchemaIssueKind = "wrong_type"
+)
+
+// SchemaIssue is a schema violation found in one or more documents.
+type SchemaIssue struct {
+	Kind SchemaIssueKind
+	Path string
+	// Expected holds the declared types of a mistyped field.
+	Expected []FieldType
+	// Found is the type a mistyped or extra field is stored as.
+	Found FieldType
+	// Count is the number of documents with the issue.
+	Count     int
+	SampleIDs []string
+}
+
+// ValidationReport summarises the schema violations found in a collection.
+type ValidationReport struct {
+	Collection       string
+	Documents        int
+	InvalidDocuments int
+	// Issues are sorted by path, then kind.
+	Issues []SchemaIssue
+}
+
+// ValidateSchema scans the collection and checks every document against def, reporting missing,
+// extra and mistyped fields along with sample document IDs. Documents are streamed with Scan, so it
+// can be run over collections of any size.
+func (s *GenericStore) ValidateSchema(ctx context.Context, def SchemaDefinition, opts SchemaScanOptions) (*ValidationReport, error) {
+	samples := opts.sampleSize()
+	report := &ValidationReport{Collection: s.collection.Path}
+	issues := make(map[string]*SchemaIssue)
+
+	declared := make(map[string]SchemaField, len(def.Fields))
+	for _, f := range def.Fields {
+		declared[f.Path] = f
+	}
+
+	record := func(docID string, issue SchemaIssue) {
+		key := string(issue.Kind) + "|" + issue.Path + "|" + string(issue.Found)
+		existing, ok := issues[key]
+		if !ok {
+			existing = &issue
+			issues[key] = existing
+		}
+		existing.Count++
+		existing.SampleIDs = appendSample(existing.SampleIDs, docID, samples)
+	}
+
+	err := s.Scan(ctx, opts.ScanOptions, func(doc *firestore.DocumentSnapshot) error {
+		report.Documents++
+		found := validateDoc(def, declared, doc.Data())
+		for _, issue := range found {
+			record(doc.Ref.ID, issue)
+		}
+		if len(found) > 0 {
+			report.InvalidDocuments++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, issue := range issues {
+		report.Issues = append(report.Issues, *issue)
+	}
+	sort.Slice(report.Issues, func(i, j int) bool {
+		a, b := report.Issues[i], report.Issues[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Found < b.Found
+	})
+	return report, nil
+}
+
+// validateDoc returns the schema violations in a single document's data.
+func validateDoc(def SchemaDefinition, declared map[string]SchemaField, data map[string]interface{}) []SchemaIssue {
+	var issues []SchemaIssue
+
+	for _, field := range def.Fields {
+		parent, ok := lookupPath(data, parentPath(field.Path))
+		if _, isMap := parent.(map[string]interface{}); !ok || !isMap {
+			// the parent is missing or mistyped, which is reported against the parent itself
+			continue
+		}
+		value, ok := lookupPath(data, field.Path)
+		if !ok {
+			if field.Required {
+				issues = append(issues, SchemaIssue{Kind: MissingField, Path: field.Path})
+			}
+			continue
+		}
+		if typ := fieldTypeOf(value); len(field.Types) > 0 && !containsType(field.Types, typ) {
+			issues = append(issues, SchemaIssue{Kind: WrongType, Path: field.Path, Expected: field.Types, Found: typ})
+		}
+	}
+
+	if def.AllowExtra {
+		return issues
+	}
+	// skip holds paths whose children should not be reported: open maps and already reported extras
+	skip := make(map[string]bool)
+	var paths []string
+	values := make(map[string]interface{})
+	walkFields("", data, func(path string, value interface{}) {
+		paths = append(paths, path)
+		values[path] = value
+	})
+	sort.Strings(paths)
+	for _, path := range paths {
+		if skip[parentPath(path)] {
+			skip[path] = true
+			continue
+		}
+		field, ok := declared[path]
+		switch {
+		case !ok:
+			issues = append(issues, SchemaIssue{Kind: ExtraField, Path: path, Found: fieldTypeOf(values[path])})
+			skip[path] = true
+		case field.Open:
+			skip[path] = true
+		}
+	}
+	return issues
+}
+
+// lookupPath returns the value at a dotted path in data. The empty path returns data itself.
+func lookupPath(data map[string]interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return data, true
+	}
+	var current interface{} = data
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func parentPath(path string) string {
+	if i := strings.LastIndex(path, "."); i >= 0 {
+		return path[:i]
+	}
+	return ""
+}
+
+func containsType(types []FieldType, typ FieldType) bool {
+	for _, t := range types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,237 @@
+package firestore
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/genproto/googleapis/type/latlng"
+)
+
+func TestFieldTypeOf(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  FieldType
+	}{
+		{nil, TypeNull},
+		{true, TypeBoolean},
+		{int64(1), TypeInteger},
+		{1.5, TypeDouble},
+		{"s", TypeString},
+		{[]byte("b"), TypeBytes},
+		{time.Now(), TypeTimestamp},
+		{&firestore.DocumentRef{}, TypeReference},
+		{&latlng.LatLng{}, TypeGeoPoint},
+		{firestore.Vector64{1}, TypeVector},
+		{firestore.Vector32{1}, TypeVector},
+		{[]interface{}{}, TypeArray},
+		{map[string]interface{}{}, TypeMap},
+		{1, FieldType("int")},
+	}
+	for _, tt := range tests {
+		if got := fieldTypeOf(tt.value); got != tt.want {
+			t.Errorf("fieldTypeOf(%#v) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestWalkFields(t *testing.T) {
+	data := map[string]interface{}{
+		"a": int64(1),
+		"b": map[string]interface{}{"c": "x", "d": map[string]interface{}{"e": nil}},
+		"f": []interface{}{map[string]interface{}{"g": 1}},
+	}
+	var got []string
+	walkFields("", data, func(path string, value interface{}) { got = append(got, path) })
+	sort.Strings(got)
+	// arrays are not descended into
+	want := []string{"a", "b", "b.c", "b.d", "b.d.e", "f"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("walkFields() visited %v, want %v", got, want)
+	}
+}
+
+func TestLookupPath(t *testing.T) {
+	data := map[string]interface{}{
+		"a": map[string]interface{}{"b": map[string]interface{}{"c": int64(1)}},
+		"s": "x",
+		"n": nil,
+	}
+	tests := []struct {
+		path   string
+		want   interface{}
+		wantOK bool
+	}{
+		{"a.b.c", int64(1), true},
+		{"a.b", map[string]interface{}{"c": int64(1)}, true},
+		{"n", nil, true},
+		{"a.x", nil, false},
+		{"s.x", nil, false},
+		{"missing", nil, false},
+		{"", data, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, ok := lookupPath(data, tt.path)
+			if ok != tt.wantOK || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lookupPath(%q) = %v, %v, want %v, %v", tt.path, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+type schemaAddress struct {
+	City string `firestore:"city"`
+	Zip  string `firestore:"zip,omitempty"`
+}
+
+type schemaBase struct {
+	Owner string `firestore:"owner"`
+}
+
+type schemaDoc struct {
+	schemaBase
+	Name      string                 `firestore:"name"`
+	Age       int                    `firestore:"age,omitempty"`
+	Score     float64                `firestore:"score"`
+	Tags      []string               `firestore:"tags"`
+	Pair      [2]int                 `firestore:"pair"`
+	Address   schemaAddress          `firestore:"address"`
+	Manager   *schemaAddress         `firestore:"manager"`
+	Attrs     map[string]interface{} `firestore:"attrs"`
+	Extra     interface{}            `firestore:"extra"`
+	Created   time.Time              `firestore:"created"`
+	Deleted   *time.Time             `firestore:"deleted,omitempty"`
+	Location  *latlng.LatLng         `firestore:"location"`
+	Parent    *firestore.DocumentRef `firestore:"parent"`
+	Embedding firestore.Vector64     `firestore:"embedding"`
+	Data      []byte                 `firestore:"data"`
+	Ignored   string                 `firestore:"-"`
+}
+
+func TestSchemaFromStruct(t *testing.T) {
+	types := func(types ...FieldType) []FieldType { return types }
+	want := SchemaDefinition{Fields: []SchemaField{
+		{Path: "owner", Types: types(TypeString), Required: true},
+		{Path: "name", Types: types(TypeString), Required: true},
+		{Path: "age", Types: types(TypeInteger)},
+		{Path: "score", Types: types(TypeDouble), Required: true},
+		{Path: "tags", Types: types(TypeArray, TypeNull), Required: true},
+		{Path: "pair", Types: types(TypeArray), Required: true},
+		{Path: "address", Types: types(TypeMap), Required: true},
+		{Path: "address.city", Types: types(TypeString), Required: true},
+		{Path: "address.zip", Types: types(TypeString)},
+		{Path: "manager", Types: types(TypeMap, TypeNull), Required: true},
+		{Path: "manager.city", Types: types(TypeString), Required: true},
+		{Path: "manager.zip", Types: types(TypeString)},
+		{Path: "attrs", Types: types(TypeMap, TypeNull), Required: true, Open: true},
+		{Path: "extra", Required: true, Open: true},
+		{Path: "created", Types: types(TypeTimestamp), Required: true},
+		{Path: "deleted", Types: types(TypeTimestamp, TypeNull)},
+		{Path: "location", Types: types(TypeGeoPoint, TypeNull), Required: true},
+		{Path: "parent", Types: types(TypeReference, TypeNull), Required: true},
+		{Path: "embedding", Types: types(TypeVector, TypeNull), Required: true},
+		{Path: "data", Types: types(TypeBytes, TypeNull), Required: true},
+	}}
+
+	for _, model := range []interface{}{schemaDoc{}, &schemaDoc{}} {
+		got, err := SchemaFromStruct(model)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("SchemaFromStruct(%T) =\n%+v\nwant\n%+v", model, got, want)
+		}
+	}
+
+	for _, model := range []interface{}{nil, map[string]interface{}{}, "x"} {
+		if _, err := SchemaFromStruct(model); err == nil {
+			t.Errorf("SchemaFromStruct(%T) succeeded, want an error", model)
+		}
+	}
+}
+
+func TestValidateDoc(t *testing.T) {
+	def := SchemaDefinition{Fields: []SchemaField{
+		{Path: "name", Types: []FieldType{TypeString}, Required: true},
+		{Path: "age", Types: []FieldType{TypeInteger}},
+		{Path: "address", Types: []FieldType{TypeMap}},
+		{Path: "address.city", Types: []FieldType{TypeString}, Required: true},
+		{Path: "meta", Types: []FieldType{TypeMap}, Open: true},
+		{Path: "any"},
+	}}
+	open := def
+	open.AllowExtra = true
+
+	tests := []struct {
+		name string
+		def  SchemaDefinition
+		data map[string]interface{}
+		want []SchemaIssue
+	}{
+		{
+			name: "valid",
+			def:  def,
+			data: map[string]interface{}{
+				"name": "a", "age": int64(1), "any": []interface{}{},
+				"address": map[string]interface{}{"city": "Paris"},
+				"meta":    map[string]interface{}{"x": map[string]interface{}{"y": int64(1)}},
+			},
+		},
+		{
+			name: "missing required field",
+			def:  def,
+			data: map[string]interface{}{"age": int64(1)},
+			want: []SchemaIssue{{Kind: MissingField, Path: "name"}},
+		},
+		{
+			name: "nested field only required when parent present",
+			def:  def,
+			data: map[string]interface{}{"name": "a", "address": map[string]interface{}{}},
+			want: []SchemaIssue{{Kind: MissingField, Path: "address.city"}},
+		},
+		{
+			name: "mistyped parent reported once",
+			def:  def,
+			data: map[string]interface{}{"name": "a", "address": "Paris"},
+			want: []SchemaIssue{{Kind: WrongType, Path: "address", Expected: []FieldType{TypeMap}, Found: TypeString}},
+		},
+		{
+			name: "wrong type",
+			def:  def,
+			data: map[string]interface{}{"name": "a", "age": 1.5},
+			want: []SchemaIssue{{Kind: WrongType, Path: "age", Expected: []FieldType{TypeInteger}, Found: TypeDouble}},
+		},
+		{
+			name: "extra field reported without its children",
+			def:  def,
+			data: map[string]interface{}{"name": "a", "other": map[string]interface{}{"x": int64(1)}},
+			want: []SchemaIssue{{Kind: ExtraField, Path: "other", Found: TypeMap}},
+		},
+		{
+			name: "nested extra field",
+			def:  def,
+			data: map[string]interface{}{"name": "a", "address": map[string]interface{}{"city": "Paris", "street": "Main"}},
+			want: []SchemaIssue{{Kind: ExtraField, Path: "address.street", Found: TypeString}},
+		},
+		{
+			name: "extra fields allowed",
+			def:  open,
+			data: map[string]interface{}{"name": "a", "other": int64(1)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			declared := make(map[string]SchemaField)
+			for _, f := range tt.def.Fields {
+				declared[f.Path] = f
+			}
+			got := validateDoc(tt.def, declared, tt.data)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateDoc() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
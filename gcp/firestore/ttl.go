@@ -0,0 +1,275 @@
+package firestore
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/maxcraig112/go-crud/errs"
+	"github.com/maxcraig112/go-crud/tracing"
+)
+
+// TTLConfig configures when a store's documents expire.
+type TTLConfig struct {
+	// ExpiryField names a timestamp field holding each document's expiry time. Documents without
+	// the field never expire.
+	ExpiryField string
+	// Duration expires documents this long after they were created. Only used when ExpiryField is
+	// empty.
+	Duration time.Duration
+}
+
+// WithTTL expires the store's documents according to cfg. Until the sweeper deletes them, expired
+// documents are hidden from every read, including Scan, aggregations, WatchCollection and the geo and
+// vector queries, and ApplyMergePatch and ApplyJSONPatch treat them as missing. Expiry is judged at
+// the context's read time when one is set with WithReadTime.
+func (s *GenericStore) WithTTL(cfg TTLConfig) *GenericStore {
+	s.ttl = &cfg
+	return s
+}
+
+// isExpired reports whether doc has expired at now under the store's TTL configuration.
+func (s *GenericStore) isExpired(doc *firestore.DocumentSnapshot, now time.Time) bool {
+	if s.ttl == nil || doc == nil || !doc.Exists() {
+		return false
+	}
+	if s.ttl.ExpiryField == "" {
+		return s.ttl.Duration > 0 && !doc.CreateTime.IsZero() && !now.Before(doc.CreateTime.Add(s.ttl.Duration))
+	}
+	value, err := doc.DataAt(s.ttl.ExpiryField)
+	if err != nil {
+		return false
+	}
+	expiry, ok := value.(time.Time)
+	return ok && !now.Before(expiry)
+}
+
+// expiryTime returns the time documents are checked for expiry at: the context's read time if it
+// has one, otherwise the current time.
+func (s *GenericStore) expiryTime(ctx context.Context) time.Time {
+	if readTime, ok := ReadTimeFrom(ctx); ok {
+		return readTime
+	}
+	return time.Now()
+}
+
+// filterExpired removes documents expired at the context's expiry time from docs.
+func (s *GenericStore) filterExpired(ctx context.Context, docs []*firestore.DocumentSnapshot) []*firestore.DocumentSnapshot {
+	if s.ttl == nil {
+		return docs
+	}
+	now := s.expiryTime(ctx)
+	live := docs[:0]
+	for _, doc := range docs {
+		if !s.isExpired(doc, now) {
+			live = append(live, doc)
+		}
+	}
+	return live
+}
+
+// defaultSweepChunkSize is the number of expired documents deleted per round trip.
+const defaultSweepChunkSize = 500
+
+// SweepExpired deletes the store's expired documents in chunks of chunkSize, returning the number
+// deleted. Documents updated since they were read are left for the next sweep. With an ExpiryField
+// only expired documents are read; with a Duration the whole collection is scanned, as Firestore
+// cannot query by creation time.
+func (s *GenericStore) SweepExpired(ctx context.Context, chunkSize int) (_ int, err error) {
+	ctx, done := s.observe(ctx, "SweepExpired")
+	defer func() { err = done(err) }()
+
+	if s.ttl == nil {
+		return 0, errs.New(errs.Invalid, "store has no TTL configured")
+	}
+	if chunkSize <= 0 {
+		chunkSize = defaultSweepChunkSize
+	}
+
+	now := time.Now()
+	var query []QueryParameter
+	if s.ttl.ExpiryField != "" {
+		query = []QueryParameter{{Path: s.ttl.ExpiryField, Op: "<=", Value: now}}
+	}
+
+	deleted := 0
+	var chunk []*firestore.DocumentSnapshot
+	flush := func() error {
+		n, err := s.deleteUnchanged(ctx, chunk)
+		deleted += n
+		chunk = chunk[:0]
+		return err
+	}
+	err = s.scan(ctx, ScanOptions{Query: query, PageSize: chunkSize}, true, func(doc *firestore.DocumentSnapshot) error {
+		if !s.isExpired(doc, now) {
+			return nil
+		}
+		chunk = append(chunk, doc)
+		if len(chunk) >= chunkSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil && len(chunk) > 0 {
+		err = flush()
+	}
+	tracing.SetAttributes(ctx, tracing.DocCountKey.Int(deleted))
+	return deleted, err
+}
+
+// deleteUnchanged deletes docs that have not been updated since they were read, returning the
+// number deleted.
+func (s *GenericStore) deleteUnchanged(ctx context.Context, docs []*firestore.DocumentSnapshot) (int, error) {
+	bulkWriter := s.client.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, len(docs))
+	for i, doc := range docs {
+		job, err := bulkWriter.Delete(doc.Ref, firestore.LastUpdateTime(doc.UpdateTime))
+		if err != nil {
+			bulkWriter.End()
+			return 0, err
+		}
+		jobs[i] = job
+	}
+	bulkWriter.End()
+
+	deleted := 0
+	for _, job := range jobs {
+		_, err := job.Results()
+		switch status.Code(err) {
+		case codes.OK:
+			deleted++
+		case codes.FailedPrecondition, codes.NotFound:
+			// changed or removed since it was read
+		default:
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// LeaderElector decides whether this process should run work that must only run in one place.
+type LeaderElector interface {
+	IsLeader(ctx context.Context) (bool, error)
+}
+
+// SweeperOptions configures StartSweeper.
+type SweeperOptions struct {
+	// Interval is the time between sweeps. Defaults to one minute.
+	Interval time.Duration
+	// ChunkSize is the number of documents deleted per round trip. Defaults to 500.
+	ChunkSize int
+	// Leader restricts sweeping to the elected leader. Nil sweeps on every instance.
+	Leader LeaderElector
+}
+
+// StartSweeper deletes expired documents in the background every opts.Interval until ctx is
+// cancelled or the returned stop function is called. Sweep failures are logged and retried on the
+// next interval.
+func (s *GenericStore) StartSweeper(ctx context.Context, opts SweeperOptions) func() {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Minute
+	}
+	sweepCtx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+		for {
+			s.sweepOnce(sweepCtx, opts)
+			select {
+			case <-sweepCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return cancel
+}
+
+func (s *GenericStore) sweepOnce(ctx context.Context, opts SweeperOptions) {
+	if opts.Leader != nil {
+		leader, err := opts.Leader.IsLeader(ctx)
+		if err != nil {
+			log.Warn().Err(err).Str("collection", s.collection.Path).Msg("Failed to check sweeper leadership")
+			return
+		}
+		if !leader {
+			return
+		}
+	}
+
+	deleted, err := s.SweepExpired(ctx, opts.ChunkSize)
+	if err != nil && ctx.Err() == nil {
+		log.Error().Err(err).Str("collection", s.collection.Path).Int("deleted", deleted).Msg("Failed to sweep expired documents")
+		return
+	}
+	if deleted > 0 {
+		log.Info().Str("collection", s.collection.Path).Int("deleted", deleted).Msg("Swept expired documents")
+	}
+}
+
+// LeaseElector elects a leader by holding a lease stored in a Firestore document. The holder renews
+// the lease every time it checks leadership; another instance takes over once the lease expires.
+type LeaseElector struct {
+	client   FirestoreClientInterface
+	ref      *firestore.DocumentRef
+	holderID string
+	ttl      time.Duration
+}
+
+// lease is the document a LeaseElector stores.
+type lease struct {
+	Holder    string    `firestore:"holder"`
+	ExpiresAt time.Time `firestore:"expiresAt"`
+}
+
+// NewLeaseElector returns an elector competing for the lease stored at leasePath, a document path
+// such as "leases/session-sweeper". holderID must be unique per instance, and ttl must be longer
+// than the interval between leadership checks so the leader keeps the lease.
+func NewLeaseElector(client FirestoreClientInterface, leasePath, holderID string, ttl time.Duration) *LeaseElector {
+	return &LeaseElector{
+		client:   client,
+		ref:      docRefAt(client, leasePath),
+		holderID: holderID,
+		ttl:      ttl,
+	}
+}
+
+// IsLeader acquires or renews the lease, reporting whether this instance holds it.
+func (e *LeaseElector) IsLeader(ctx context.Context) (bool, error) {
+	if e.ref == nil {
+		return false, errs.New(errs.Invalid, "lease path must name a document")
+	}
+
+	leader := false
+	err := e.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		leader = false
+		now := time.Now()
+
+		snap, err := tx.Get(e.ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if snap != nil && snap.Exists() {
+			var current lease
+			if err := snap.DataTo(&current); err != nil {
+				return err
+			}
+			if current.Holder != e.holderID && now.Before(current.ExpiresAt) {
+				return nil
+			}
+		}
+
+		leader = true
+		return tx.Set(e.ref, lease{Holder: e.holderID, ExpiresAt: now.Add(e.ttl)})
+	})
+	if err != nil {
+		return false, err
+	}
+	return leader, nil
+}
@@ -0,0 +1,131 @@
+package firestore
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+
+	"github.com/maxcraig112/go-crud/errs"
+)
+
+// UpdateBuilder builds the updates for UpdateDoc, validating every field path against the firestore
+// tags of a model struct so typos fail before reaching the database.
+type UpdateBuilder struct {
+	model   reflect.Type
+	updates []firestore.Update
+	err     error
+}
+
+// NewUpdateBuilder returns a builder validating field paths against model, a struct or struct pointer.
+// A nil model disables validation.
+func NewUpdateBuilder(model interface{}) *UpdateBuilder {
+	b := &UpdateBuilder{}
+	if model == nil {
+		return b
+	}
+	t := reflect.TypeOf(model)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		b.err = errs.Newf(errs.Invalid, "update model must be a struct, got %s", t)
+	}
+	b.model = t
+	return b
+}
+
+// Path joins field names into a nested field path, e.g. Path("address", "city") is "address.city".
+func Path(fields ...string) string {
+	return strings.Join(fields, ".")
+}
+
+// Set sets the field at path to value.
+func (b *UpdateBuilder) Set(path string, value interface{}) *UpdateBuilder {
+	return b.add(path, value, nil)
+}
+
+// Increment atomically adds n to the numeric field at path.
+func (b *UpdateBuilder) Increment(path string, n interface{}) *UpdateBuilder {
+	return b.add(path, firestore.Increment(n), isNumericKind)
+}
+
+// ArrayUnion atomically adds elems to the array field at path, skipping elements already present.
+func (b *UpdateBuilder) ArrayUnion(path string, elems ...interface{}) *UpdateBuilder {
+	return b.add(path, firestore.ArrayUnion(elems...), isArrayKind)
+}
+
+// ArrayRemove atomically removes every instance of elems from the array field at path.
+func (b *UpdateBuilder) ArrayRemove(path string, elems ...interface{}) *UpdateBuilder {
+	return b.add(path, firestore.ArrayRemove(elems...), isArrayKind)
+}
+
+// ServerTimestamp sets the field at path to the time the update is committed.
+func (b *UpdateBuilder) ServerTimestamp(path string) *UpdateBuilder {
+	return b.add(path, firestore.ServerTimestamp, nil)
+}
+
+// DeleteField removes the field at path from the document.
+func (b *UpdateBuilder) DeleteField(path string) *UpdateBuilder {
+	return b.add(path, firestore.Delete, nil)
+}
+
+// Build returns the updates, or the first validation error encountered.
+func (b *UpdateBuilder) Build() ([]firestore.Update, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if len(b.updates) == 0 {
+		return nil, errs.New(errs.Invalid, "update has no fields")
+	}
+	return b.updates, nil
+}
+
+func (b *UpdateBuilder) add(path string, value interface{}, kindOK func(reflect.Type) bool) *UpdateBuilder {
+	if b.err != nil {
+		return b
+	}
+
+	fieldPath := strings.Split(path, ".")
+	if b.model != nil {
+		t, ok := lookupFieldType(b.model, fieldPath)
+		if !ok {
+			b.err = errs.Newf(errs.Invalid, "unknown field %q on %s", path, b.model)
+			return b
+		}
+		if kindOK != nil && t.Kind() != reflect.Interface && !kindOK(t) {
+			b.err = errs.Newf(errs.Invalid, "field %q of type %s does not support this update", path, t)
+			return b
+		}
+	}
+
+	b.updates = append(b.updates, firestore.Update{FieldPath: fieldPath, Value: value})
+	return b
+}
+
+func isNumericKind(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func isArrayKind(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice || t.Kind() == reflect.Array
+}
+
+// UpdateDocWith applies the updates built by b to the document with the given ID.
+func (s *GenericStore) UpdateDocWith(ctx context.Context, docID string, b *UpdateBuilder) error {
+	updates, err := b.Build()
+	if err != nil {
+		return err
+	}
+	return s.UpdateDoc(ctx, docID, updates)
+}
@@ -0,0 +1,163 @@
+package firestore
+
+import (
+	"context"
+	"math"
+
+	"cloud.google.com/go/firestore"
+
+	"github.com/maxcraig112/go-crud/errs"
+	"github.com/maxcraig112/go-crud/tracing"
+)
+
+type DistanceMeasure string
+
+var (
+	Euclidean  DistanceMeasure = "euclidean"
+	Cosine     DistanceMeasure = "cosine"
+	DotProduct DistanceMeasure = "dot_product"
+)
+
+// maxNearestLimit is the largest number of neighbours Firestore returns from a vector query.
+const maxNearestLimit = 1000
+
+// defaultDistanceField is the field the computed distance is written to in returned snapshots
+// when NearestOptions.DistanceField is not set.
+const defaultDistanceField = "_distance"
+
+// NearestOptions configures a FindNearest query.
+type NearestOptions struct {
+	// Filter restricts the documents searched. Equality filters combined with a vector search need
+	// a composite vector index.
+	Filter []QueryParameter
+	// DistanceThreshold excludes less similar documents. For DotProduct, documents with a distance
+	// below the threshold are excluded; for the other measures, documents above it are.
+	DistanceThreshold *float64
+	// DistanceField is the field the computed distance is written to in the returned snapshots.
+	// The stored documents are not modified. Defaults to "_distance".
+	DistanceField string
+}
+
+// NearestResult is a document returned by FindNearest along with its distance from the query vector.
+type NearestResult struct {
+	Doc      *firestore.DocumentSnapshot
+	Distance float64
+}
+
+// FindNearest returns up to limit documents whose vectorField is closest to queryVector according to
+// measure, ordered from nearest to furthest. Only documents storing vectorField as a Firestore vector
+// (see Vector) of the same dimension are considered; plain arrays are ignored.
+func (s *GenericStore) FindNearest(ctx context.Context, vectorField string, queryVector []float64, measure DistanceMeasure, limit int, opts NearestOptions) (_ []NearestResult, err error) {
+	ctx, done := s.observe(ctx, "FindNearest", tracing.QueryKey.String(queryShape(opts.Filter)))
+	defer func() { err = done(err) }()
+
+	if len(queryVector) == 0 {
+		return nil, errs.New(errs.Invalid, "query vector must not be empty")
+	}
+	if limit <= 0 || limit > maxNearestLimit {
+		return nil, errs.Newf(errs.Invalid, "limit must be between 1 and %d", maxNearestLimit)
+	}
+	distanceMeasure, err := measure.firestore()
+	if err != nil {
+		return nil, err
+	}
+
+	distanceField := opts.DistanceField
+	if distanceField == "" {
+		distanceField = defaultDistanceField
+	}
+	s.trackVectorQuery(opts.Filter, vectorField, len(queryVector))
+
+	vectorQuery := s.buildQuery(ctx, opts.Filter).FindNearest(vectorField, firestore.Vector64(queryVector), limit, distanceMeasure, &firestore.FindNearestOptions{
+		DistanceThreshold:   opts.DistanceThreshold,
+		DistanceResultField: distanceField,
+	})
+
+	var docs []*firestore.DocumentSnapshot
+	err = s.withRetry(ctx, "FindNearest", true, func(ctx context.Context) error {
+		iter := vectorQuery.Documents(ctx)
+		defer iter.Stop()
+		docs, err = iter.GetAll()
+		return err
+	})
+	if err != nil {
+		return nil, s.indexError(opts.Filter, err)
+	}
+
+	docs = s.filterExpired(ctx, docs)
+	results := make([]NearestResult, len(docs))
+	for i, doc := range docs {
+		results[i] = NearestResult{Doc: doc}
+		if distance, err := doc.DataAt(distanceField); err == nil {
+			results[i].Distance, _ = toFloat(distance)
+		}
+	}
+	return results, nil
+}
+
+func (m DistanceMeasure) firestore() (firestore.DistanceMeasure, error) {
+	switch m {
+	case Euclidean:
+		return firestore.DistanceMeasureEuclidean, nil
+	case Cosine:
+		return firestore.DistanceMeasureCosine, nil
+	case DotProduct:
+		return firestore.DistanceMeasureDotProduct, nil
+	default:
+		return 0, errs.Newf(errs.Invalid, "unsupported distance measure: %s", m)
+	}
+}
+
+// Vector converts values to a Firestore vector. Embeddings must be stored as vectors rather than
+// plain arrays to be searchable with FindNearest. Accepted types are []float64, []float32,
+// firestore.Vector64 and firestore.Vector32.
+func Vector(values interface{}) (firestore.Vector64, error) {
+	switch v := values.(type) {
+	case firestore.Vector64:
+		return v, nil
+	case []float64:
+		return firestore.Vector64(v), nil
+	case firestore.Vector32:
+		return float32sToVector(v), nil
+	case []float32:
+		return float32sToVector(v), nil
+	default:
+		return nil, errs.Newf(errs.Invalid, "cannot convert %T to a vector", values)
+	}
+}
+
+func float32sToVector(values []float32) firestore.Vector64 {
+	out := make(firestore.Vector64, len(values))
+	for i, v := range values {
+		out[i] = float64(v)
+	}
+	return out
+}
+
+// NormalizeVector scales values to unit length, so that DotProduct gives the same ordering as
+// Cosine at a lower cost. A zero vector is returned unchanged.
+func NormalizeVector(values []float64) []float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v * v
+	}
+	out := make([]float64, len(values))
+	if sum == 0 {
+		copy(out, values)
+		return out
+	}
+	norm := math.Sqrt(sum)
+	for i, v := range values {
+		out[i] = v / norm
+	}
+	return out
+}
+
+// SetVector stores values as a Firestore vector at field on the document with the given ID.
+func (s *GenericStore) SetVector(ctx context.Context, docID string, field string, values interface{}) error {
+	vector, err := Vector(values)
+	if err != nil {
+		return err
+	}
+	return s.UpdateDoc(ctx, docID, []firestore.Update{{Path: field, Value: vector}})
+}
@@ -0,0 +1,293 @@
+// Package fixtures loads seed data described in JSON or YAML files into Firestore and Cloud Storage,
+// for setting up demo and test environments.
+//
+// A fixture file lists documents by collection and ID. Document values use the tagged forms of
+// firestore.JSONCodec, so references to other documents are written as {"$ref": "users/bob"} and
+// timestamps as {"$timestamp": "2024-01-01T00:00:00Z"}; unquoted YAML timestamps are stored as
+// timestamps too. Subcollections are nested under a
+// document's "$collections" key. Bucket objects are given inline or read from a file relative to
+// the fixture file.
+//
+//	collections:
+//	  users:
+//	    alice:
+//	      name: Alice
+//	      manager: {$ref: users/bob}
+//	      $collections:
+//	        orders:
+//	          o1: {total: 10}
+//	    bob:
+//	      name: Bob
+//	objects:
+//	  - name: avatars/alice.txt
+//	    content: hello
+//	  - name: avatars/bob.png
+//	    file: testdata/bob.png
+package fixtures
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/maxcraig112/go-crud/errs"
+	"github.com/maxcraig112/go-crud/gcp/bucket"
+	"github.com/maxcraig112/go-crud/gcp/firestore"
+)
+
+// collectionsKey holds a document's subcollections in a fixture file.
+const collectionsKey = "$collections"
+
+// Fixture is a parsed fixture file.
+type Fixture struct {
+	Docs    []Doc
+	Objects []Object
+}
+
+// Doc is a document in a fixture, with its values already decoded into Firestore-native types.
+type Doc struct {
+	// Collection is the path of the document's collection, e.g. "users" or "users/alice/orders".
+	Collection string
+	ID         string
+	Data       map[string]interface{}
+}
+
+// Path returns the path of the document relative to its database.
+func (d Doc) Path() string {
+	return d.Collection + "/" + d.ID
+}
+
+// Object is a bucket object in a fixture.
+type Object struct {
+	Name string `json:"name" yaml:"name"`
+	// Content is the object's data. Ignored if File is set.
+	Content string `json:"content,omitempty" yaml:"content,omitempty"`
+	// File is the path of a file holding the object's data, relative to the fixture file.
+	File string `json:"file,omitempty" yaml:"file,omitempty"`
+}
+
+// fixtureFile is the structure of a fixture file. Collections map collection IDs to documents by ID.
+type fixtureFile struct {
+	Collections map[string]interface{} `yaml:"collections"`
+	Objects     []Object               `yaml:"objects"`
+}
+
+// Loader applies fixtures to a Firestore database and, optionally, a bucket.
+type Loader struct {
+	client firestore.FirestoreClientInterface
+	codec  *firestore.JSONCodec
+	bucket *bucket.GenericBucket
+	stores map[string]*firestore.GenericStore
+}
+
+// NewLoader returns a loader writing documents through client.
+func NewLoader(client firestore.FirestoreClientInterface) *Loader {
+	return &Loader{
+		client: client,
+		codec:  firestore.NewJSONCodec(client, firestore.JSONOptions{}),
+		stores: make(map[string]*firestore.GenericStore),
+	}
+}
+
+// WithStore writes and deletes the fixture documents of collection, e.g. "users", through store, so
+// the store's geo indexes and retry policy apply to them. Other collections use a default store.
+func (l *Loader) WithStore(collection string, store *firestore.GenericStore) *Loader {
+	l.stores[collection] = store
+	return l
+}
+
+// store returns the store for documents in collection.
+func (l *Loader) store(collection string) *firestore.GenericStore {
+	if store, ok := l.stores[collection]; ok {
+		return store
+	}
+	store := firestore.NewGenericStore(l.client, collection)
+	l.stores[collection] = store
+	return store
+}
+
+// WithBucket sets the bucket fixture objects are uploaded to.
+func (l *Loader) WithBucket(b *bucket.GenericBucket) *Loader {
+	l.bucket = b
+	return l
+}
+
+// ParseFile reads a JSON or YAML fixture file. Object files are resolved relative to the fixture
+// file's directory.
+func (l *Loader) ParseFile(name string) (*Fixture, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, errs.Wrap(errs.KindOf(err), err, "failed to read fixture "+name)
+	}
+	fixture, err := l.Parse(data)
+	if err != nil {
+		return nil, errs.Wrap(errs.KindOf(err), err, "failed to parse fixture "+name)
+	}
+	dir := filepath.Dir(name)
+	for i, obj := range fixture.Objects {
+		if obj.File != "" && !filepath.IsAbs(obj.File) {
+			fixture.Objects[i].File = filepath.Join(dir, obj.File)
+		}
+	}
+	return fixture, nil
+}
+
+// Parse parses a JSON or YAML fixture. As JSON is valid YAML, both are read with the same parser,
+// which keeps whole-number floats such as 10.0 as doubles.
+func (l *Loader) Parse(data []byte) (*Fixture, error) {
+	var file fixtureFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, errs.Wrap(errs.Invalid, err, "invalid fixture")
+	}
+
+	fixture := &Fixture{Objects: file.Objects}
+	for _, obj := range fixture.Objects {
+		if obj.Name == "" {
+			return nil, errs.New(errs.Invalid, "fixture object is missing a name")
+		}
+	}
+	if err := l.addCollections(fixture, "", file.Collections); err != nil {
+		return nil, err
+	}
+	return fixture, nil
+}
+
+// addCollections decodes the documents of collections nested under parent, adding parents before
+// their subcollections.
+func (l *Loader) addCollections(fixture *Fixture, parent string, collections map[string]interface{}) error {
+	for _, collectionID := range sortedKeys(collections) {
+		collection := path.Join(parent, collectionID)
+		docs, ok := collections[collectionID].(map[string]interface{})
+		if !ok {
+			return errs.Newf(errs.Invalid, "collection %s must map document IDs to documents", collection)
+		}
+		for _, id := range sortedKeys(docs) {
+			fields, ok := docs[id].(map[string]interface{})
+			if !ok {
+				return errs.Newf(errs.Invalid, "document %s/%s must be an object", collection, id)
+			}
+
+			var subcollections map[string]interface{}
+			if sub, ok := fields[collectionsKey]; ok {
+				if subcollections, ok = sub.(map[string]interface{}); !ok {
+					return errs.Newf(errs.Invalid, "invalid %s in document %s/%s", collectionsKey, collection, id)
+				}
+				delete(fields, collectionsKey)
+			}
+
+			data, err := l.codec.DecodeMap(fields)
+			if err != nil {
+				return errs.Wrap(errs.KindOf(err), err, "failed to decode document "+collection+"/"+id)
+			}
+			fixture.Docs = append(fixture.Docs, Doc{Collection: collection, ID: id, Data: data})
+
+			if err := l.addCollections(fixture, collection+"/"+id, subcollections); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Load writes every document and object in the fixture. Documents are written with their fixed IDs,
+// replacing any existing document, so loading the same fixture again leaves the data unchanged.
+func (l *Loader) Load(ctx context.Context, fixture *Fixture) error {
+	if err := l.loadDocs(ctx, fixture.Docs); err != nil {
+		return err
+	}
+
+	if len(fixture.Objects) == 0 {
+		return nil
+	}
+	if l.bucket == nil {
+		return errs.New(errs.Invalid, "fixture has objects but the loader has no bucket")
+	}
+	for _, obj := range fixture.Objects {
+		if err := l.loadObject(ctx, obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadDocs writes docs in one batch per collection, in the order collections first appear so parent
+// documents are written before their subcollections.
+func (l *Loader) loadDocs(ctx context.Context, docs []Doc) error {
+	for _, group := range groupByCollection(docs) {
+		data := make([]interface{}, len(group))
+		ids := make([]string, len(group))
+		for i, doc := range group {
+			data[i], ids[i] = doc.Data, doc.ID
+		}
+		collection := group[0].Collection
+		if err := l.store(collection).SetDocsBatch(ctx, data, ids); err != nil {
+			return errs.Wrap(errs.KindOf(err), err, "failed to load "+collection)
+		}
+	}
+	return nil
+}
+
+// groupByCollection splits docs by collection, keeping the order in which collections first appear.
+func groupByCollection(docs []Doc) [][]Doc {
+	var groups [][]Doc
+	index := make(map[string]int)
+	for _, doc := range docs {
+		i, ok := index[doc.Collection]
+		if !ok {
+			i = len(groups)
+			index[doc.Collection] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], doc)
+	}
+	return groups
+}
+
+func (l *Loader) loadObject(ctx context.Context, obj Object) error {
+	if obj.File == "" {
+		return l.bucket.CreateObject(ctx, obj.Name, strings.NewReader(obj.Content))
+	}
+	f, err := os.Open(obj.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return l.bucket.CreateObject(ctx, obj.Name, f)
+}
+
+// Teardown deletes every document and object in the fixture, subcollection documents first.
+// Documents and objects that no longer exist are ignored, so teardown can be run repeatedly.
+// Documents created outside the fixture, including in the fixture's subcollections, are not
+// deleted.
+func (l *Loader) Teardown(ctx context.Context, fixture *Fixture) error {
+	if l.bucket != nil {
+		for _, obj := range fixture.Objects {
+			err := l.bucket.DeleteObject(ctx, obj.Name)
+			if err != nil && errs.KindOf(err) != errs.NotFound {
+				return err
+			}
+		}
+	}
+
+	for i := len(fixture.Docs) - 1; i >= 0; i-- {
+		doc := fixture.Docs[i]
+		if err := l.store(doc.Collection).DeleteDoc(ctx, doc.ID); err != nil {
+			return errs.Wrap(errs.KindOf(err), err, "failed to delete "+doc.Path())
+		}
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
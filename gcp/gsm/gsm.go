@@ -3,10 +3,16 @@ package gsm
 import (
 	"context"
 	"fmt"
+	"time"
 
 	secretmanager "cloud.google.com/go/secretmanager/apiv1"
 	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"github.com/joho/godotenv"
+
+	"github.com/maxcraig112/go-crud/errs"
+	"github.com/maxcraig112/go-crud/metrics"
+	"github.com/maxcraig112/go-crud/retry"
+	"github.com/maxcraig112/go-crud/tracing"
 )
 
 // NewGSMClient initializes and returns a GSMClient.
@@ -20,19 +26,38 @@ func NewGSMClient(ctx context.Context) (*GSMClient, error) {
 }
 
 // GetJWTSecret retrieves the JWT secret from Google Secret Manager using the secret name in .env.
-func (g *GSMClient) GetSecret(ctx context.Context, projectID string, secretName string) (string, error) {
+func (g *GSMClient) GetSecret(ctx context.Context, projectID string, secretName string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "gsm", "GetSecret", tracing.SecretKey.String(secretName))
+	start := time.Now()
+	defer func() {
+		err = errs.From(err)
+		tracing.End(span, err)
+		metrics.ObserveOperation("gsm", "GetSecret", secretName, start, errs.Code(err).String())
+	}()
+
 	// Format: projects/{project}/secrets/{secret}/versions/{secretVersion}
 	secretPath := fmt.Sprintf("projects/%s/secrets/%s/versions/%s", projectID, secretName, "latest")
 	req := &secretmanagerpb.AccessSecretVersionRequest{
 		Name: secretPath,
 	}
-	result, err := g.client.AccessSecretVersion(ctx, req)
+	var result *secretmanagerpb.AccessSecretVersionResponse
+	err = g.retryPolicy.Do(ctx, "gsm", "GetSecret", true, func(ctx context.Context) error {
+		result, err = g.client.AccessSecretVersion(ctx, req)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
 	return string(result.Payload.Data), nil
 }
 
+// WithRetryPolicy sets the policy used to retry secret lookups that fail with transient errors.
+// By default lookups are attempted once.
+func (g *GSMClient) WithRetryPolicy(policy retry.Policy) *GSMClient {
+	g.retryPolicy = policy
+	return g
+}
+
 func (g *GSMClient) Close() error {
 	return g.client.Close()
 }
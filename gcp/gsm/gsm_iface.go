@@ -4,6 +4,8 @@ import (
 	"context"
 
 	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+
+	"github.com/maxcraig112/go-crud/retry"
 )
 
 // GSMClientInterface defines methods for GSM operations.
@@ -13,5 +15,6 @@ type GSMClientInterface interface {
 }
 
 type GSMClient struct {
-	client *secretmanager.Client
+	client      *secretmanager.Client
+	retryPolicy retry.Policy
 }
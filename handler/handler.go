@@ -6,6 +6,9 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/maxcraig112/go-crud/gcp"
+	"github.com/maxcraig112/go-crud/metrics"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 type Handler struct {
@@ -35,16 +38,19 @@ func (h *Handler) WithMiddleware(mws func(http.Handler) http.Handler) *Handler {
 
 // Register sets up the routes with the provided router, applying all middleware defined.
 func (h *Handler) Register(method, pattern string, handlerFunc http.HandlerFunc) {
-	var wrapped http.Handler
+	var wrapped http.Handler = handlerFunc
 	// apply handler specific middleware
 	for _, mw := range h.Mws {
-		if wrapped == nil {
-			wrapped = mw(handlerFunc)
-		} else {
-			wrapped = mw(wrapped)
-		}
+		wrapped = mw(wrapped)
 	}
 
+	wrapped = metrics.HTTPMiddleware(method, pattern)(wrapped)
+
+	// extract any incoming W3C trace context and start a server span for the route
+	wrapped = otelhttp.NewHandler(wrapped, method+" "+pattern,
+		otelhttp.WithPropagators(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})),
+	)
+
 	h.Router.Handle(pattern, wrapped).Methods(method)
 
 }
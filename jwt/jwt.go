@@ -2,7 +2,6 @@ package jwt
 
 import (
 	"context"
-	"errors"
 	"net/http"
 	"os"
 	"strings"
@@ -10,12 +9,15 @@ import (
 
 	jwtlib "github.com/golang-jwt/jwt/v5"
 	"github.com/rs/zerolog/log"
+
+	"github.com/maxcraig112/go-crud/errs"
+	"github.com/maxcraig112/go-crud/metrics"
 )
 
 func GetAuthTokenString(r *http.Request) (string, error) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
-		return "", errors.New("missing or invalid Authorization header")
+		return "", errs.New(errs.Unauthorized, "missing or invalid Authorization header")
 	}
 	return strings.TrimPrefix(authHeader, "Bearer "), nil
 }
@@ -23,15 +25,20 @@ func GetAuthTokenString(r *http.Request) (string, error) {
 func AuthMiddleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
 			tokenString, err := GetAuthTokenString(r)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusUnauthorized)
+				observe("Authenticate", start, err)
+				errs.WriteHTTP(w, err)
 				return
 			}
 
 			secret := os.Getenv("JWT_SECRET")
 			if secret == "" {
-				http.Error(w, "Could not retrieve JWT secret", http.StatusInternalServerError)
+				err := errs.New(errs.Internal, "could not retrieve JWT secret")
+				observe("Authenticate", start, err)
+				errs.WriteHTTP(w, err)
 				return
 			}
 
@@ -42,9 +49,12 @@ func AuthMiddleware() func(http.Handler) http.Handler {
 				return []byte(secret), nil
 			})
 			if err != nil || !token.Valid {
-				http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+				err := errs.Wrap(errs.Unauthorized, err, "invalid or expired token")
+				observe("Authenticate", start, err)
+				errs.WriteHTTP(w, err)
 				return
 			}
+			observe("Authenticate", start, nil)
 
 			// Optionally, set claims in context for downstream handlers
 			type contextKey string
@@ -55,12 +65,20 @@ func AuthMiddleware() func(http.Handler) http.Handler {
 	}
 }
 
+// observe records the outcome of a JWT operation.
+func observe(operation string, start time.Time, err error) {
+	metrics.ObserveOperation("jwt", operation, "", start, errs.Code(err).String())
+}
+
 // JWT and validation helpers
 func GenerateJWT(ctx context.Context, userID string, email string) (string, error) {
+	start := time.Now()
 	secret := os.Getenv("JWT_SECRET")
 	if secret == "" {
 		log.Error().Msg("JWT_SECRET environment variable not set for JWT generation")
-		return "", errors.New("JWT_SECRET ENVIRONMENT VARIABLE NOT SET")
+		err := errs.New(errs.Internal, "JWT_SECRET environment variable not set")
+		observe("Generate", start, err)
+		return "", err
 	}
 	claims := jwtlib.MapClaims{
 		"userID": userID,
@@ -72,17 +90,23 @@ func GenerateJWT(ctx context.Context, userID string, email string) (string, erro
 	signed, err := token.SignedString([]byte(secret))
 	if err != nil {
 		log.Error().Err(err).Str("userID", userID).Msg("Failed to sign JWT")
+		err = errs.Wrap(errs.Internal, err, "failed to sign JWT")
+		observe("Generate", start, err)
 		return "", err
 	}
+	observe("Generate", start, nil)
 	log.Info().Str("userID", userID).Msg("JWT generated successfully")
 	return signed, nil
 }
 
 func GetJWTClaims(tokenString string) (jwtlib.MapClaims, error) {
+	start := time.Now()
 	secret := os.Getenv("JWT_SECRET")
 	if secret == "" {
 		log.Error().Msg("JWT_SECRET environment variable not set")
-		return nil, jwtlib.ErrTokenMalformed
+		err := errs.Wrap(errs.Internal, jwtlib.ErrTokenMalformed, "JWT_SECRET environment variable not set")
+		observe("Parse", start, err)
+		return nil, err
 	}
 	token, err := jwtlib.Parse(tokenString, func(token *jwtlib.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwtlib.SigningMethodHMAC); !ok {
@@ -93,14 +117,19 @@ func GetJWTClaims(tokenString string) (jwtlib.MapClaims, error) {
 	})
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to parse JWT token")
+		err = errs.Wrap(errs.Unauthorized, err, "failed to parse JWT")
+		observe("Parse", start, err)
 		return nil, err
 	}
 	if claims, ok := token.Claims.(jwtlib.MapClaims); ok && token.Valid {
 		log.Info().Msg("JWT token claims extracted successfully")
+		observe("Parse", start, nil)
 		return claims, nil
 	}
 	log.Error().Msg("JWT token expired or invalid claims")
-	return nil, jwtlib.ErrTokenExpired
+	err = errs.Wrap(errs.Unauthorized, jwtlib.ErrTokenExpired, "invalid JWT claims")
+	observe("Parse", start, err)
+	return nil, err
 }
 
 func GetUserIDFromJWT(r *http.Request) (string, error) {
@@ -117,7 +146,7 @@ func GetUserIDFromJWT(r *http.Request) (string, error) {
 	claimUserID, ok := claims["userID"].(string)
 	if !ok {
 		log.Error().Msg("Failed to parse userID from JWT claims")
-		return "", errors.New("invalid token claims")
+		return "", errs.New(errs.Unauthorized, "invalid token claims")
 	}
 	return claimUserID, nil
 }
@@ -130,7 +159,7 @@ func ValidateJWT(r *http.Request, userID string) error {
 	}
 	if claimUserID != userID {
 		log.Error().Msg("UserID in token does not match provided userID")
-		return errors.New("userID mismatch in token")
+		return errs.New(errs.Forbidden, "userID mismatch in token")
 	}
 	log.Info().Msg("JWT token claims validated and token is valid")
 	return nil
@@ -0,0 +1,236 @@
+// Package metrics collects operation counters and latency histograms for the go-crud packages and
+// exposes them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the latency histogram buckets, in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds a set of metrics.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+type metric interface {
+	write(w io.Writer, name string)
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// Default is the registry that the go-crud packages record their metrics to.
+var Default = NewRegistry()
+
+// Handler serves the default registry in the Prometheus text format.
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// Counter returns the counter with the given name, registering it if it does not already exist.
+// It panics if name is already registered as a different type of metric.
+func (r *Registry) Counter(name, help string, labelNames ...string) *CounterVec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.metrics[name]; ok {
+		m, ok := existing.(*CounterVec)
+		if !ok {
+			panic(fmt.Sprintf("metrics: %s is already registered as a %T", name, existing))
+		}
+		return m
+	}
+	c := &CounterVec{help: help, labelNames: labelNames, values: make(map[string]*counterValue)}
+	r.metrics[name] = c
+	return c
+}
+
+// Histogram returns the histogram with the given name, registering it if it does not already exist.
+// It panics if name is already registered as a different type of metric.
+func (r *Registry) Histogram(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.metrics[name]; ok {
+		m, ok := existing.(*HistogramVec)
+		if !ok {
+			panic(fmt.Sprintf("metrics: %s is already registered as a %T", name, existing))
+		}
+		return m
+	}
+	h := &HistogramVec{help: help, buckets: buckets, labelNames: labelNames, values: make(map[string]*histogramValue)}
+	r.metrics[name] = h
+	return h
+}
+
+// Handler serves the registry's metrics in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// Write writes every metric in the registry to w in the Prometheus text format.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	names := sortedKeys(r.metrics)
+	metrics := make([]metric, len(names))
+	for i, name := range names {
+		metrics[i] = r.metrics[name]
+	}
+	r.mu.Unlock()
+
+	for i, name := range names {
+		metrics[i].write(w, name)
+	}
+}
+
+// CounterVec is a counter partitioned by label values.
+type CounterVec struct {
+	help       string
+	labelNames []string
+
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels string
+	value  float64
+}
+
+// Inc increments the counter for the given label values by one.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter for the given label values by v.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	labels := formatLabels(c.labelNames, labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cv, ok := c.values[labels]
+	if !ok {
+		cv = &counterValue{labels: labels}
+		c.values[labels] = cv
+	}
+	cv.value += v
+}
+
+func (c *CounterVec) write(w io.Writer, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, c.help, name)
+	for _, labels := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", name, braced(labels), formatFloat(c.values[labels].value))
+	}
+}
+
+// HistogramVec is a histogram partitioned by label values.
+type HistogramVec struct {
+	help       string
+	buckets    []float64
+	labelNames []string
+
+	mu     sync.Mutex
+	values map[string]*histogramValue
+}
+
+type histogramValue struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe records v in the histogram for the given label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	labels := formatLabels(h.labelNames, labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hv, ok := h.values[labels]
+	if !ok {
+		hv = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[labels] = hv
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			hv.counts[i]++
+		}
+	}
+	hv.count++
+	hv.sum += v
+}
+
+func (h *HistogramVec) write(w io.Writer, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, h.help, name)
+	for _, labels := range sortedKeys(h.values) {
+		hv := h.values[labels]
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, braced(joinLabels(labels, `le="`+formatFloat(upper)+`"`)), hv.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, braced(joinLabels(labels, `le="+Inf"`)), hv.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, braced(labels), formatFloat(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, braced(labels), hv.count)
+	}
+}
+
+// formatLabels renders label pairs as `a="x",b="y"`, which also serves as the series key.
+func formatLabels(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = name + `="` + escapeLabelValue(value) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func joinLabels(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func braced(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprintf("%g", v)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	operationTotal = Default.Counter("gocrud_operation_total",
+		"Total operations against GCP services and auth helpers.",
+		"component", "operation", "target", "code")
+	operationDuration = Default.Histogram("gocrud_operation_duration_seconds",
+		"Latency of operations against GCP services and auth helpers.",
+		DefaultBuckets, "component", "operation", "target")
+
+	httpRequestsTotal = Default.Counter("gocrud_http_requests_total",
+		"Total HTTP requests served by registered handlers.",
+		"method", "route", "code")
+	httpRequestDuration = Default.Histogram("gocrud_http_request_duration_seconds",
+		"Latency of HTTP requests served by registered handlers.",
+		DefaultBuckets, "method", "route")
+)
+
+// ObserveOperation records a completed operation. The target is the collection, bucket or secret the
+// operation ran against, and code is the operation's result, usually errs.Code(err).String().
+func ObserveOperation(component, operation, target string, start time.Time, code string) {
+	operationTotal.Inc(component, operation, target, code)
+	operationDuration.Observe(time.Since(start).Seconds(), component, operation, target)
+}
+
+// HTTPMiddleware records the count and latency of requests to route.
+func HTTPMiddleware(method, route string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+
+			httpRequestsTotal.Inc(method, route, strconv.Itoa(sw.status))
+			httpRequestDuration.Observe(time.Since(start).Seconds(), method, route)
+		})
+	}
+}
+
+// statusWriter captures the status code written by a handler.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -1,18 +1,31 @@
 package password
 
 import (
+	"errors"
+
 	"golang.org/x/crypto/bcrypt"
+
+	"github.com/maxcraig112/go-crud/errs"
 )
 
 func HashPassword(password string) (string, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		return "", errs.Wrap(errs.Invalid, err, "failed to hash password")
+	}
 	if err != nil {
-		return "", err
+		return "", errs.Wrap(errs.Internal, err, "failed to hash password")
 	}
 	return string(hashedPassword), nil
 }
 
 func CheckPasswordHash(password, hash string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return errs.Wrap(errs.Unauthorized, err, "password does not match")
+	}
+	if err != nil {
+		return errs.Wrap(errs.Internal, err, "failed to compare password hash")
+	}
+	return nil
 }
@@ -0,0 +1,143 @@
+// Package retry retries operations that fail with transient GCP errors using exponential backoff.
+package retry
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+
+	"github.com/maxcraig112/go-crud/errs"
+	"github.com/maxcraig112/go-crud/metrics"
+)
+
+var retriesTotal = metrics.Default.Counter("gocrud_retries_total",
+	"Total retries of operations that failed with a transient error.",
+	"component", "operation", "code")
+
+// Policy configures how an operation is retried. The zero value performs a single attempt.
+type Policy struct {
+	// MaxAttempts is the total number of attempts, including the first. Values below 2 disable retries.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts.
+	MaxBackoff time.Duration
+	// Multiplier grows the backoff after every retry.
+	Multiplier float64
+	// Jitter randomises each backoff by up to this fraction in either direction.
+	Jitter float64
+	// Budget, if set, limits retries across every operation sharing it.
+	Budget *Budget
+}
+
+// Default returns a policy suited to riding out brief service blips.
+func Default() Policy {
+	return Policy{
+		MaxAttempts:    4,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// Do calls fn until it succeeds, returns a non-transient error, or the policy's attempts are exhausted.
+// Idempotent operations are retried on Unavailable, DeadlineExceeded, Aborted and ResourceExhausted.
+// Other operations are only retried on Unavailable and ResourceExhausted, where the request was
+// rejected before being applied.
+func (p Policy) Do(ctx context.Context, component, operation string, idempotent bool, fn func(ctx context.Context) error) error {
+	backoff := p.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			if attempt == 1 && p.Budget != nil {
+				p.Budget.deposit()
+			}
+			return nil
+		}
+
+		code := errs.Code(err)
+		if attempt >= p.MaxAttempts || !Retryable(code, idempotent) || ctx.Err() != nil {
+			return err
+		}
+		if p.Budget != nil && !p.Budget.withdraw() {
+			log.Warn().Err(err).Str("component", component).Str("operation", operation).Msg("Retry budget exhausted")
+			return err
+		}
+
+		wait := p.jittered(backoff)
+		retriesTotal.Inc(component, operation, code.String())
+		log.Warn().Err(err).
+			Str("component", component).
+			Str("operation", operation).
+			Int("attempt", attempt).
+			Dur("backoff", wait).
+			Msg("Retrying transient error")
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		backoff = time.Duration(float64(backoff) * p.Multiplier)
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+func (p Policy) jittered(d time.Duration) time.Duration {
+	if p.Jitter <= 0 {
+		return d
+	}
+	delta := (rand.Float64()*2 - 1) * p.Jitter * float64(d)
+	return time.Duration(float64(d) + delta)
+}
+
+// Retryable reports whether an error with the given code is worth retrying.
+func Retryable(code codes.Code, idempotent bool) bool {
+	switch code {
+	case codes.Unavailable, codes.ResourceExhausted:
+		return true
+	case codes.DeadlineExceeded, codes.Aborted:
+		return idempotent
+	}
+	return false
+}
+
+// Budget limits retries across operations so a struggling backend is not overwhelmed. Every retry
+// spends one token, and every operation that succeeds first time refunds Ratio tokens.
+type Budget struct {
+	mu     sync.Mutex
+	tokens float64
+	max    float64
+	ratio  float64
+}
+
+// NewBudget returns a full budget holding maxTokens tokens.
+func NewBudget(maxTokens int, ratio float64) *Budget {
+	return &Budget{tokens: float64(maxTokens), max: float64(maxTokens), ratio: ratio}
+}
+
+func (b *Budget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *Budget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.tokens+b.ratio, b.max)
+}
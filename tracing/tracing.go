@@ -0,0 +1,54 @@
+// Package tracing provides the OpenTelemetry instrumentation shared by the go-crud packages.
+//
+// Spans are created with the globally registered TracerProvider, so instrumentation is a no-op until
+// a service installs one with otel.SetTracerProvider.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/maxcraig112/go-crud/errs"
+)
+
+const instrumentationName = "github.com/maxcraig112/go-crud"
+
+// Attribute keys recorded on go-crud spans.
+const (
+	CollectionKey = attribute.Key("firestore.collection")
+	DocIDKey      = attribute.Key("firestore.doc_id")
+	DocCountKey   = attribute.Key("firestore.doc_count")
+	QueryKey      = attribute.Key("firestore.query")
+	BucketKey     = attribute.Key("gcs.bucket")
+	ObjectKey     = attribute.Key("gcs.object")
+	BytesKey      = attribute.Key("gcs.bytes")
+	SecretKey     = attribute.Key("gsm.secret")
+	ResultCodeKey = attribute.Key("result.code")
+)
+
+// Start starts a client span for an operation on component, e.g. "firestore.GetDoc".
+func Start(ctx context.Context, component, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, component+"."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// End records the result of the operation on span and ends it.
+func End(span trace.Span, err error) {
+	span.SetAttributes(ResultCodeKey.String(errs.Code(err).String()))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	span.End()
+}
+
+// SetAttributes adds attributes to the span carried by ctx, such as byte counts only known after the call.
+func SetAttributes(ctx context.Context, attrs ...attribute.KeyValue) {
+	trace.SpanFromContext(ctx).SetAttributes(attrs...)
+}